@@ -252,6 +252,66 @@ func TestExamples(t *testing.T) {
 			args:    []string{"variant", "test"},
 			wd:      "./examples/conditional_run",
 		},
+		{
+			subject: "examples/timeout",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/timeout",
+		},
+		{
+			subject: "examples/retry",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/retry",
+		},
+		{
+			subject: "examples/conditional_step",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/conditional_step",
+		},
+		{
+			subject: "examples/for_each",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/for_each",
+		},
+		{
+			subject: "examples/matrix",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/matrix",
+		},
+		{
+			subject: "examples/on_failure",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/on_failure",
+		},
+		{
+			subject: "examples/finally",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/finally",
+		},
+		{
+			subject: "examples/exec_guards",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/exec_guards",
+		},
+		{
+			subject: "examples/stdin",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/stdin",
+		},
+		{
+			subject: "examples/script",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/script",
+		},
+		{
+			subject: "examples/env",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/env",
+		},
+		{
+			subject: "examples/outputs",
+			args:    []string{"variant", "test"},
+			wd:      "./examples/outputs",
+		},
 		{
 			subject: "examples/advaned/terraform-and-helmfile-wrapper",
 			args:    []string{"variant", "test"},
@@ -1,11 +1,13 @@
 package app
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime/debug"
 	"sort"
@@ -23,7 +25,6 @@ import (
 	"github.com/kr/text"
 	"github.com/pkg/errors"
 	"github.com/variantdev/dag/pkg/dag"
-	"github.com/variantdev/mod/pkg/shell"
 	"github.com/variantdev/mod/pkg/variantmod"
 	"github.com/variantdev/vals"
 	ctyyaml "github.com/zclconf/go-cty-yaml"
@@ -42,35 +43,82 @@ const (
 	FormatYAML = "yaml"
 
 	FormatText = "text"
+
+	// TimeoutExitStatus is the exit status of a command that has been terminated due to timeout.
+	// It is the same as the one used by GNU coreutils' timeout.
+	TimeoutExitStatus = 124
 )
 
 func (app *App) Run(cmd string, args map[string]interface{}, opts map[string]interface{}, fs ...SetOptsFunc) (*Result, error) {
+	return app.RunContext(context.Background(), cmd, args, opts, fs...)
+}
+
+// RunContext is the same as Run, except that the job run and all the commands started by it are stopped once ctx is done.
+func (app *App) RunContext(ctx context.Context, cmd string, args map[string]interface{}, opts map[string]interface{}, fs ...SetOptsFunc) (*Result, error) {
+	return app.RunContextWithStdin(ctx, nil, cmd, args, opts, fs...)
+}
+
+// RunContextWithStdin is the same as RunContext, except that stdin is fed to the `exec` of the job.
+// The `stdin` and `stdin_file` attributes of the `exec` takes precedence over it.
+func (app *App) RunContextWithStdin(ctx context.Context, stdin io.Reader, cmd string, args map[string]interface{}, opts map[string]interface{}, fs ...SetOptsFunc) (*Result, error) {
 	var f SetOptsFunc
 	if len(fs) > 0 {
 		f = fs[0]
 	}
 
-	jr, err := app.Job(nil, nil, cmd, args, opts, f, true)
+	if err := validateOutputMode(app.OutputMode); err != nil {
+		return nil, err
+	}
+
+	parallelism, err := app.newRunSemaphore()
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := jr()
+	jobCtx := &JobContext{stdin: stdin, parallelism: parallelism}
+
+	if app.DryRun {
+		jobCtx.execMatcher = &execMatcher{record: true, out: app.Stdout, printExec: app.printDryRunExec}
+	} else if app.StateDir != "" {
+		st, err := app.openRunState(cmd)
+		if err != nil {
+			return nil, err
+		}
+
+		jobCtx.runState = st
+	}
+
+	jr, err := app.Job(ctx, jobCtx, nil, cmd, args, opts, f, true)
 	if err != nil {
 		return nil, err
 	}
 
+	res, err := jr()
+
+	if jobCtx.runState != nil {
+		if stErr := app.closeRunState(jobCtx.runState, err); stErr != nil && err == nil {
+			err = stErr
+		}
+	}
+
+	if err != nil {
+		// The result is returned along with the error so that the caller can see e.g. the exit status and the timeout
+		return res, err
+	}
+
 	return res, nil
 }
 
-func (app *App) run(jobCtx *JobContext, l *EventLogger, cmd string, args map[string]interface{}, streamOutput bool) (*Result, error) {
+func (app *App) run(ctx context.Context, jobCtx *JobContext, l *EventLogger, cmd string, args map[string]interface{}, streamOutput bool) (*Result, error) {
+	app.addSensitiveArgs(cmd, args)
+
 	if l != nil {
 		if err := l.LogRun(cmd, args); err != nil {
 			return nil, err
 		}
 	}
 
-	jr, err := app.Job(jobCtx, l, cmd, args, args, nil, streamOutput)
+	jr, err := app.Job(ctx, jobCtx, l, cmd, args, args, nil, streamOutput)
 	if err != nil {
 		if cmd != "" {
 			return nil, xerrors.Errorf("job %q: %w", cmd, err)
@@ -82,16 +130,16 @@ func (app *App) run(jobCtx *JobContext, l *EventLogger, cmd string, args map[str
 	res, err := jr()
 	if err != nil {
 		if cmd != "" {
-			return nil, xerrors.Errorf("job %q: %w", cmd, err)
+			return res, xerrors.Errorf("job %q: %w", cmd, err)
 		}
 
-		return nil, err
+		return res, err
 	}
 
 	return res, nil
 }
 
-func (app *App) Job(jobCtx *JobContext, l *EventLogger, cmd string, args map[string]interface{}, opts map[string]interface{}, f SetOptsFunc, streamOutput bool) (func() (*Result, error), error) {
+func (app *App) Job(ctx context.Context, jobCtx *JobContext, l *EventLogger, cmd string, args map[string]interface{}, opts map[string]interface{}, f SetOptsFunc, streamOutput bool) (func() (*Result, error), error) {
 	jobByName := app.JobByName
 
 	j, cmdDefined := jobByName[cmd]
@@ -104,17 +152,68 @@ func (app *App) Job(jobCtx *JobContext, l *EventLogger, cmd string, args map[str
 		}
 	}
 
-	return func() (*Result, error) {
+	return func() (res *Result, err error) {
+		if j.ExitCodeOnFailure != nil {
+			if err := validateExitCode(*j.ExitCodeOnFailure); err != nil {
+				return nil, err
+			}
+
+			defer func() {
+				if err != nil {
+					err = &Error{Err: err, ExitCode: *j.ExitCodeOnFailure}
+				}
+			}()
+		}
+
 		cc := app.Config
 
 		// execMatcher is the only object that is inherited from the parent to the child jobContext
 		var execMatcher *execMatcher
 
+		// runState is given only to the job run by the command, as the context created by RunContext.
+		// It is never inherited, so that only the steps of that job are checkpointed.
+		var runState *runState
+
+		// stdin is likewise given only to the job run by the command
+		var stdin io.Reader
+
+		// env is inherited from the caller, and merged with the one of this job
+		env := map[string]string{}
+
+		// executor is inherited from the caller unless this job selects its own
+		var executor Executor
+
+		// stdout and stderr of the step calling this job
+		var stdout, stderr io.Writer
+
+		// locks held by the callers
+		var locks map[string]*heldLock
+
+		// setOpts asks for the approval of this job. It is given to the job run by the command, and inherited by the callees
+		setOpts := f
+
+		// parallelism is shared by all the jobs in the run
+		var parallelism semaphore
+
 		if jobCtx != nil {
 			execMatcher = jobCtx.execMatcher
+			runState = jobCtx.runState
+			stdin = jobCtx.stdin
+			executor = jobCtx.executor
+			stdout, stderr = jobCtx.stdout, jobCtx.stderr
+			locks = jobCtx.locks
+			parallelism = jobCtx.parallelism
+
+			if setOpts == nil {
+				setOpts = jobCtx.setOpts
+			}
+
+			for k, v := range jobCtx.env {
+				env[k] = v
+			}
 		}
 
-		jobCtx, err := app.createJobContext(cc, j, args, opts, f)
+		jobCtx, err := app.createJobContext(ctx, cc, j, args, opts, f)
 		if err != nil {
 			app.PrintError(err)
 
@@ -122,12 +221,93 @@ func (app *App) Job(jobCtx *JobContext, l *EventLogger, cmd string, args map[str
 		}
 
 		jobCtx.execMatcher = execMatcher
+		jobCtx.stdout, jobCtx.stderr = stdout, stderr
+		jobCtx.locks = locks
+		jobCtx.setOpts = setOpts
+		jobCtx.parallelism = parallelism
+
+		if runState != nil {
+			argsHash, hashErr := resultCacheKey(j.Name, nil, jobCtx.evalContext)
+			if hashErr != nil {
+				return nil, xerrors.Errorf("hashing args: %w", hashErr)
+			}
+
+			if err := runState.bindArgs(argsHash); err != nil {
+				return nil, err
+			}
+		}
+
+		dryRun := execMatcher != nil && execMatcher.record
+
+		if dryRun {
+			app.printDryRunJob(execMatcher.out, j.Name)
+		}
 
 		jobEvalCtx := jobCtx.evalContext
 
+		if !IsExpressionEmpty(j.Env) {
+			var jobEnv map[string]string
+
+			if diags := gohcl2.DecodeExpression(j.Env, jobEvalCtx, &jobEnv); diags.HasErrors() {
+				return nil, xerrors.Errorf("env: %w", diags)
+			}
+
+			for k, v := range jobEnv {
+				env[k] = v
+			}
+		}
+
+		jobCtx.env = env
+
+		if !IsExpressionEmpty(j.Executor) {
+			var name string
+
+			if diags := gohcl2.DecodeExpression(j.Executor, jobEvalCtx, &name); diags.HasErrors() {
+				return nil, xerrors.Errorf("executor: %w", diags)
+			}
+
+			executor, err = app.namedExecutor(name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		jobCtx.executor = executor
+
+		// The job is approved before the timeout starts, so that the time waiting for the approver doesn't count
+		if !dryRun {
+			if err := app.approve(jobCtx, j, setOpts); err != nil {
+				return nil, err
+			}
+		}
+
+		if !IsExpressionEmpty(j.Timeout) {
+			timeout, err := decodeDuration(j.Timeout, jobEvalCtx)
+			if err != nil {
+				return nil, xerrors.Errorf("timeout: %w", err)
+			}
+
+			var cancel context.CancelFunc
+
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		if !dryRun {
+			lockedCtx, release, lockErr := app.withLock(ctx, jobCtx, j.Lock, j.LockTimeout)
+			if lockErr != nil {
+				return nil, lockErr
+			}
+
+			defer release()
+
+			jobCtx = lockedCtx
+		}
+
 		if l == nil {
 			l = NewEventLogger(cmd, args, opts)
 			l.Stderr = app.Stderr
+			l.Sanitize = app.sanitize
 
 			if app.Trace != "" {
 				l.Register(app.newTracingLogCollector())
@@ -150,7 +330,7 @@ func (app *App) Job(jobCtx *JobContext, l *EventLogger, cmd string, args map[str
 				}
 			}
 
-			logCollector := app.newLogCollector(file, j, jobCtx)
+			logCollector := app.newLogCollector(ctx, file, j, jobCtx)
 			unregister := l.Register(logCollector)
 
 			defer func() {
@@ -176,8 +356,114 @@ func (app *App) Job(jobCtx *JobContext, l *EventLogger, cmd string, args map[str
 			}
 		}
 
+		//nolint:nestif
+		if j.Cache != nil && !dryRun {
+			cacheKey, keyErr := resultCacheKey(j.Name, j.Cache.Key, jobEvalCtx)
+			if keyErr != nil {
+				return nil, xerrors.Errorf("cache: %w", keyErr)
+			}
+
+			var ttl time.Duration
+
+			if !IsExpressionEmpty(j.Cache.TTL) {
+				d, ttlErr := decodeDuration(j.Cache.TTL, jobEvalCtx)
+				if ttlErr != nil {
+					return nil, xerrors.Errorf("cache: ttl: %w", ttlErr)
+				}
+
+				ttl = d
+			}
+
+			cached, hit, getErr := app.getCachedResult(cacheKey)
+			if getErr != nil {
+				return nil, xerrors.Errorf("cache: %w", getErr)
+			}
+
+			if hit {
+				if logErr := l.append(Event{
+					Type: "run:cached",
+					Time: time.Now(),
+					Run: &RunEvent{
+						Job:  j.Name,
+						Args: args,
+					},
+				}); logErr != nil {
+					return nil, logErr
+				}
+
+				// Replay the output as it would have been streamed if the job were actually run
+				if streamOutput {
+					app.replayOutput(cached)
+				}
+
+				return cached, nil
+			}
+
+			// Only successful results are cached
+			defer func() {
+				if err == nil && res != nil {
+					if putErr := app.putCachedResult(cacheKey, j.Name, ttl, res); putErr != nil {
+						err = xerrors.Errorf("cache: %w", putErr)
+					}
+				}
+			}()
+		}
+
+		//nolint:nestif
+		if (!IsExpressionEmpty(j.Inputs) || !IsExpressionEmpty(j.Outputs)) && !dryRun {
+			check, checkErr := app.newUpToDateCheck(j, jobEvalCtx)
+			if checkErr != nil {
+				return nil, checkErr
+			}
+
+			upToDate, outputValues, checkErr := check.upToDate()
+			if checkErr != nil {
+				return nil, checkErr
+			}
+
+			if upToDate {
+				if logErr := l.append(Event{
+					Type: "run:up-to-date",
+					Time: time.Now(),
+					Run: &RunEvent{
+						Job:  j.Name,
+						Args: args,
+					},
+				}); logErr != nil {
+					return nil, logErr
+				}
+
+				// The outputs of the last successful run are returned, so that the callers can refer to them as usual
+				return &Result{Skipped: true, Outputs: outputValues}, nil
+			}
+
+			defer func() {
+				if err == nil && res != nil {
+					if saveErr := check.save(res.Outputs); saveErr != nil {
+						err = xerrors.Errorf("saving hashes of inputs: %w", saveErr)
+					}
+				}
+			}()
+		}
+
 		needs := map[string]cty.Value{}
 
+		if len(j.Finally) > 0 {
+			defer func() {
+				res, err = app.execFinally(l, jobCtx, j.Finally, needs, res, err, streamOutput)
+			}()
+		}
+
+		// Outputs are evaluated before `finally`, so that they are available as `run.res.outputs` to it,
+		// and before the result is cached
+		if len(j.OutputValues) > 0 && !dryRun {
+			defer func() {
+				if err == nil && res != nil && !res.Skipped {
+					res.Outputs, err = evaluateOutputs(jobEvalCtx, j.OutputValues, res, needs)
+				}
+			}()
+		}
+
 		var concurrency int
 
 		if !IsExpressionEmpty(j.Concurrency) {
@@ -194,6 +480,27 @@ func (app *App) Job(jobCtx *JobContext, l *EventLogger, cmd string, args map[str
 			concurrency = 1
 		}
 
+		// Walk through steps one by one in the dry-run mode, so that the output is predictable
+		if dryRun {
+			concurrency = 1
+		}
+
+		onFailure := OnFailureFailFast
+
+		if !IsExpressionEmpty(j.OnFailure) {
+			if err := gohcl2.DecodeExpression(j.OnFailure, jobEvalCtx, &onFailure); err != nil {
+				app.PrintDiags(err)
+
+				return nil, err
+			}
+
+			switch onFailure {
+			case OnFailureFailFast, OnFailureContinue, OnFailureFinishWave:
+			default:
+				return nil, fmt.Errorf("on_failure %q is not supported. It must be one of %q, %q and %q", onFailure, OnFailureFailFast, OnFailureContinue, OnFailureFinishWave)
+			}
+		}
+
 		var depStdout string
 
 		var lastDepRes *Result
@@ -205,7 +512,7 @@ func (app *App) Job(jobCtx *JobContext, l *EventLogger, cmd string, args map[str
 
 					var err error
 
-					lastDepRes, err = app.execMultiRun(l, jobCtx, &d, streamOutput)
+					lastDepRes, err = app.execMultiRun(ctx, l, jobCtx, &d, concurrency, streamOutput)
 					if err != nil {
 						return nil, err
 					}
@@ -215,11 +522,11 @@ func (app *App) Job(jobCtx *JobContext, l *EventLogger, cmd string, args map[str
 			}
 		}
 
-		if err := app.checkoutSources(l, jobCtx, j.Sources, concurrency); err != nil {
+		if err := app.checkoutSources(ctx, l, jobCtx, j.Sources, concurrency); err != nil {
 			return nil, err
 		}
 
-		r, err := app.execJobSteps(l, jobCtx, needs, j.Steps, concurrency, streamOutput)
+		r, err := app.execJobSteps(ctx, l, jobCtx, needs, j.Steps, concurrency, onFailure, runState, streamOutput)
 		if err != nil {
 			app.PrintDiags(err)
 
@@ -227,7 +534,7 @@ func (app *App) Job(jobCtx *JobContext, l *EventLogger, cmd string, args map[str
 		}
 
 		if r == nil {
-			jobRes, err := app.execJob(l, j, jobCtx, streamOutput)
+			jobRes, err := app.execJob(ctx, l, j, jobCtx, stdin, streamOutput)
 			if err != nil {
 				app.PrintDiags(err)
 
@@ -303,118 +610,101 @@ type Command struct {
 	Dir  string
 
 	Interactive bool
-}
-
-func (app *App) execCmd(ctx *JobContext, cmd Command, log bool) (*Result, error) {
-	var execM *execMatcher
-
-	if ctx != nil {
-		execM = ctx.execMatcher
-	}
 
-	if execM == nil {
-		execM = &execMatcher{}
-	}
+	// Timeout is the maximum duration the command is allowed to run. Zero means no timeout.
+	Timeout time.Duration
 
-	// If we have one ore more pending exec expectations, never run the actual command.
-	// Instead, do validate the execCmd run against the expectation.
-	if len(execM.expectedExecs) > 0 {
-		execM.execInvocationCount++
+	// InheritEnv is the names of the os envvars inherited by the command. nil means that all of them are inherited
+	InheritEnv []string
 
-		expectation := execM.expectedExecs[0]
+	// Stdin is the input fed to the command
+	Stdin io.Reader
+	// StdinFile is the path to the file fed to the command. It is relative to Dir
+	StdinFile string
 
-		if cmd.Name != expectation.Command {
-			return nil, fmt.Errorf("unexpected exec %d: expected command %q, got %q", execM.execInvocationCount, expectation.Command, cmd.Name)
-		}
+	// Script is the script run by the interpreter, which is Name and Args.
+	// The path to the temporary file containing the script is inserted into Args at ScriptPos.
+	Script    string
+	ScriptPos int
+	// ScriptRange is the range of the script in the .variant source, used to report the line numbers of errors
+	ScriptRange hcl2.Range
 
-		if diff := cmp.Diff(expectation.Args, cmd.Args); diff != "" {
-			return nil, fmt.Errorf("unexpected exec %d: expected args %v, got %v", execM.execInvocationCount, expectation.Args, cmd.Args)
-		}
-
-		if diff := cmp.Diff(expectation.Dir, cmd.Dir); diff != "" {
-			return nil, fmt.Errorf("unexpected exec %d: expected dir %q, got %q", execM.execInvocationCount, expectation.Dir, cmd.Dir)
-		}
+	// Stdout and Stderr receive the output of the command line by line as it is produced, in addition to it being
+	// captured into the Result. nil means that the output is only captured
+	Stdout io.Writer
+	Stderr io.Writer
+}
 
-		// Pop the successful command expectation so that on next execCmd call, we can
-		// use expectedExecs[0] as the next expectation to be checked.
-		execM.expectedExecs = execM.expectedExecs[1:]
+func (app *App) execCmd(ctx context.Context, jobCtx *JobContext, cmd Command, log bool) (*Result, error) {
+	executor := app.executor(jobCtx)
 
-		return &Result{Validated: true}, nil
-	} else if execM.execInvocationCount > 0 {
-		return nil, fmt.Errorf("unexpected exec %d: fix the test by adding an expect block for this exec, or fix the test target: %v", execM.execInvocationCount+1, cmd)
+	// Test expectations and the dry-run mode are handled by the execMatcher, so that the actual command is never run
+	if jobCtx != nil && jobCtx.execMatcher != nil && jobCtx.execMatcher.intercepts() {
+		return jobCtx.execMatcher.Run(ctx, cmd)
 	}
 
-	env := map[string]string{}
-
-	// We need to explicitly inherit os envvars.
-	// Otherwise the command is executed in an env that misses all of them, including the important one like PATH,
-	// which is confusing to users.
-	// Perhaps in the future, we could introduce a `exec` block attribute to optionally turn off the inheritance.
-	for _, e := range os.Environ() {
-		pair := strings.Split(e, "=")
+	// Waiting for the slot does not count towards the timeout of the command
+	if jobCtx != nil {
+		release, err := jobCtx.parallelism.acquire(ctx)
+		if err != nil {
+			return nil, xerrors.Errorf("waiting to run command %q: %w", cmd.Name, err)
+		}
 
-		env[pair[0]] = pair[1]
+		defer release()
 	}
 
-	for k, v := range cmd.Env {
-		env[k] = v
-	}
+	parentCtx := ctx
 
-	shellCmd := &shell.Command{
-		Name: cmd.Name,
-		Args: cmd.Args,
-		Env:  env,
-		Dir:  cmd.Dir,
-	}
+	if cmd.Timeout > 0 {
+		var cancel context.CancelFunc
 
-	sh := shell.Shell{
-		Exec: shell.DefaultExec,
+		ctx, cancel = context.WithTimeout(ctx, cmd.Timeout)
+		defer cancel()
 	}
 
-	var err error
-
-	var re *Result
+	var writers []*sanitizingWriter
 
-	if cmd.Interactive {
-		shellCmd.Stdin = os.Stdin
-		shellCmd.Stdout = os.Stdout
-		shellCmd.Stderr = os.Stderr
+	if log {
+		stdout := &sanitizingWriter{app: app, w: app.stdout(jobCtx)}
+		stderr := &sanitizingWriter{app: app, w: app.stderr(jobCtx)}
 
-		res := sh.Wait(shellCmd)
+		cmd.Stdout, cmd.Stderr = stdout, stderr
 
-		err = res.Error
+		writers = append(writers, stdout, stderr)
+	}
 
+	re, err := executor.Run(ctx, cmd)
+	if re == nil {
 		re = &Result{}
-	} else {
-		var opts shell.CaptureOpts
+	}
 
-		if log {
-			opts.LogStdout = func(line string) {
-				fmt.Fprintf(app.Stdout, "%s\n", line)
-			}
-			opts.LogStderr = func(line string) {
-				fmt.Fprintf(app.Stderr, "%s\n", line)
-			}
+	for _, w := range writers {
+		if flushErr := w.Flush(); flushErr != nil && err == nil {
+			err = flushErr
 		}
+	}
 
-		var res *shell.CaptureResult
-
-		res, err = sh.Capture(shellCmd, opts)
-
-		re = &Result{
-			Stdout: res.Stdout,
-			Stderr: res.Stderr,
+	if err != nil {
+		// Prefer reporting the cancellation over e.g. "signal: killed" caused by it
+		if ctxErr := ctx.Err(); errors.Is(ctxErr, context.DeadlineExceeded) {
+			re.TimedOut = true
+			re.ExitStatus = TimeoutExitStatus
+
+			// Tell the timeout of this command from the one of the job or the whole run
+			if cmd.Timeout > 0 && parentCtx.Err() == nil {
+				err = xerrors.Errorf("timed out after %v: %w", cmd.Timeout, ctxErr)
+			} else {
+				err = xerrors.Errorf("timed out: %w", ctxErr)
+			}
+		} else if ctxErr != nil {
+			err = ctxErr
 		}
-	}
 
-	//nolint
-	switch e := err.(type) {
-	case *exec.ExitError:
-		re.ExitStatus = e.ExitCode()
-	}
+		msg := fmt.Sprintf("command \"%s %s\"", cmd.Name, strings.Join(cmd.Args, " "))
 
-	if err != nil {
-		msg := app.sanitize(fmt.Sprintf("command \"%s %s\"", cmd.Name, strings.Join(cmd.Args, " ")))
+		if cmd.Script != "" {
+			msg = fmt.Sprintf("script at %s", cmd.ScriptRange)
+		}
 
 		if cmd.Dir != "" {
 			msg += fmt.Sprintf(" in %q", cmd.Dir)
@@ -428,21 +718,134 @@ COMBINED OUTPUT:
 			)
 		}
 
-		return re, errors.Wrap(err, msg)
+		err = errors.Wrap(err, app.sanitize(msg))
+
+		// The exit status of the command is passed through to the variant process, unless mapped by exit_code_on_failure
+		if re.ExitStatus > 0 {
+			err = &Error{Err: err, ExitCode: re.ExitStatus}
+		}
+
+		return re, err
 	}
 
 	return re, nil
 }
 
-func (app *App) sanitize(str string) string {
-	return str
-}
-
-func (app *App) execJob(l *EventLogger, j JobSpec, jobCtx *JobContext, streamOutput bool) (*Result, error) {
+func (app *App) execJob(ctx context.Context, l *EventLogger, j JobSpec, jobCtx *JobContext, stdin io.Reader, streamOutput bool) (*Result, error) {
 	var res *Result
 
 	var err error
 
+	evalCtx := jobCtx.evalContext
+
+	if j.Exec != nil {
+		var last *Command
+
+		onRetry := func(attempt int, delay time.Duration, lastErr error) error {
+			var evt *ExecEvent
+
+			// last is nil when the exec failed before running the command, e.g. on an invalid expression
+			if last != nil {
+				evt = &ExecEvent{Command: last.Name, Args: last.Args}
+			}
+
+			return l.LogRetry(nil, evt, attempt, delay, lastErr)
+		}
+
+		// stdin is read only once and fed to every attempt, as the first attempt drains it
+		var stdinBuf []byte
+
+		stdinRead := false
+
+		res, err = withRetry(ctx, j.Exec.Retry, evalCtx, onRetry, func(attempt int) (*Result, error) {
+			attemptEvalCtx := evalCtx
+
+			if j.Exec.Retry != nil {
+				attemptEvalCtx = cloneEvalContext(evalCtx)
+				attemptEvalCtx.Variables["retry"] = retryVal(attempt, nil, nil)
+			}
+
+			c, err := decodeExec(j.Exec, attemptEvalCtx)
+			if err != nil {
+				return nil, err
+			}
+
+			last = c
+
+			if c.Stdin == nil && c.StdinFile == "" {
+				c.Stdin = stdin
+
+				if j.Exec.Retry != nil && stdin != nil {
+					if !stdinRead {
+						bs, err := ioutil.ReadAll(stdin)
+						if err != nil {
+							return nil, xerrors.Errorf("reading stdin: %w", err)
+						}
+
+						stdinBuf, stdinRead = bs, true
+					}
+
+					c.Stdin = bytes.NewReader(stdinBuf)
+				}
+			}
+
+			c.Env = mergeEnv(jobCtx.env, c.Env)
+
+			// Guards are never evaluated in the dry-run mode, because the checks may run commands
+			if jobCtx.execMatcher == nil || !jobCtx.execMatcher.record {
+				reason, err := app.execSkipReason(ctx, l, jobCtx, j.Exec, c, attemptEvalCtx)
+				if err != nil {
+					return nil, err
+				}
+
+				if reason != "" {
+					if err := l.LogExecSkipped(c.Name, c.Args, reason); err != nil {
+						return nil, err
+					}
+
+					return &Result{Skipped: true}, nil
+				}
+			}
+
+			res, err := app.execCmd(ctx, jobCtx, *c, streamOutput)
+			if err := l.LogExec(c.Name, c.Args); err != nil {
+				return nil, err
+			}
+
+			return res, err
+		})
+	} else {
+		var jobExists bool
+
+		res, jobExists, err = app.runJobInBody(ctx, l, jobCtx, j.Body, streamOutput)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !jobExists && j.Assert != nil {
+			for _, a := range j.Assert {
+				if err2 := app.execAssert(evalCtx, a); err2 != nil {
+					return nil, err2
+				}
+			}
+
+			return &Result{}, nil
+		}
+	}
+
+	if j.Assert != nil && len(j.Assert) > 0 {
+		for _, a := range j.Assert {
+			if err2 := app.execAssert(evalCtx, a); err2 != nil {
+				return nil, err2
+			}
+		}
+	}
+
+	return res, err
+}
+
+func decodeExec(e *Exec, evalCtx *hcl2.EvalContext) (*Command, error) {
 	var cmd string
 
 	var args []string
@@ -451,72 +854,112 @@ func (app *App) execJob(l *EventLogger, j JobSpec, jobCtx *JobContext, streamOut
 
 	var dir string
 
-	evalCtx := jobCtx.evalContext
+	var script string
 
-	//nolint:nestif
-	if j.Exec != nil {
-		if diags := gohcl2.DecodeExpression(j.Exec.Command, evalCtx, &cmd); diags.HasErrors() {
+	if e.Script != nil && !IsExpressionEmpty(e.Script) {
+		if diags := gohcl2.DecodeExpression(e.Script, evalCtx, &script); diags.HasErrors() {
 			return nil, diags
 		}
+	}
+
+	if script == "" {
+		if e.Interpreter != nil && !IsExpressionEmpty(e.Interpreter) {
+			return nil, fmt.Errorf("interpreter cannot be used without script")
+		}
+
+		if diags := gohcl2.DecodeExpression(e.Command, evalCtx, &cmd); diags.HasErrors() {
+			return nil, diags
+		}
+	} else if !IsExpressionEmpty(e.Command) {
+		return nil, fmt.Errorf("command and script cannot be used together")
+	}
 
-		if diags := gohcl2.DecodeExpression(j.Exec.Args, evalCtx, &args); diags.HasErrors() {
+	if !IsExpressionEmpty(e.Args) {
+		if diags := gohcl2.DecodeExpression(e.Args, evalCtx, &args); diags.HasErrors() {
 			return nil, diags
 		}
+	}
+
+	if diags := gohcl2.DecodeExpression(e.Env, evalCtx, &env); diags.HasErrors() {
+		return nil, diags
+	}
 
-		if diags := gohcl2.DecodeExpression(j.Exec.Env, evalCtx, &env); diags.HasErrors() {
+	if !IsExpressionEmpty(e.Dir) {
+		if diags := gohcl2.DecodeExpression(e.Dir, evalCtx, &dir); diags.HasErrors() {
 			return nil, diags
 		}
+	}
 
-		if !IsExpressionEmpty(j.Exec.Dir) {
-			if diags := gohcl2.DecodeExpression(j.Exec.Dir, evalCtx, &dir); diags.HasErrors() {
+	c := &Command{
+		Name: cmd,
+		Args: args,
+		Env:  env,
+		Dir:  dir,
+	}
+
+	if script != "" {
+		interpreter := DefaultInterpreter
+
+		if e.Interpreter != nil && !IsExpressionEmpty(e.Interpreter) {
+			if diags := gohcl2.DecodeExpression(e.Interpreter, evalCtx, &interpreter); diags.HasErrors() {
 				return nil, diags
 			}
-		}
 
-		c := Command{
-			Name: cmd,
-			Args: args,
-			Env:  env,
-			Dir:  dir,
+			if len(interpreter) == 0 {
+				return nil, fmt.Errorf("interpreter must not be empty")
+			}
 		}
 
-		if j.Exec.Interactive != nil && *j.Exec.Interactive {
-			c.Interactive = true
+		c.Name = interpreter[0]
+		c.Args = append(append([]string{}, interpreter[1:]...), args...)
+		c.Script = script
+		c.ScriptPos = len(interpreter) - 1
+		c.ScriptRange = e.Script.Range()
+	}
+
+	if e.Interactive != nil && *e.Interactive {
+		c.Interactive = true
+	}
+
+	if e.EnvInherit != nil && !IsExpressionEmpty(e.EnvInherit) {
+		var err error
+
+		c.InheritEnv, err = decodeEnvInherit(e.EnvInherit, evalCtx)
+		if err != nil {
+			return nil, xerrors.Errorf("env_inherit: %w", err)
 		}
+	}
+
+	if e.Stdin != nil && !IsExpressionEmpty(e.Stdin) {
+		var stdin string
 
-		res, err = app.execCmd(jobCtx, c, streamOutput)
-		if err := l.LogExec(cmd, args); err != nil {
-			return nil, err
+		if diags := gohcl2.DecodeExpression(e.Stdin, evalCtx, &stdin); diags.HasErrors() {
+			return nil, diags
 		}
-	} else {
-		var jobExists bool
 
-		res, jobExists, err = app.runJobInBody(l, jobCtx, j.Body, streamOutput)
+		c.Stdin = strings.NewReader(stdin)
+	}
 
-		if err != nil {
-			return nil, err
+	if e.StdinFile != nil && !IsExpressionEmpty(e.StdinFile) {
+		if c.Stdin != nil {
+			return nil, fmt.Errorf("stdin and stdin_file cannot be used together")
 		}
 
-		if !jobExists && j.Assert != nil {
-			for _, a := range j.Assert {
-				if err2 := app.execAssert(evalCtx, a); err2 != nil {
-					return nil, err2
-				}
-			}
-
-			return &Result{}, nil
+		if diags := gohcl2.DecodeExpression(e.StdinFile, evalCtx, &c.StdinFile); diags.HasErrors() {
+			return nil, diags
 		}
 	}
 
-	if j.Assert != nil && len(j.Assert) > 0 {
-		for _, a := range j.Assert {
-			if err2 := app.execAssert(evalCtx, a); err2 != nil {
-				return nil, err2
-			}
+	if !IsExpressionEmpty(e.Timeout) {
+		var err error
+
+		c.Timeout, err = decodeDuration(e.Timeout, evalCtx)
+		if err != nil {
+			return nil, xerrors.Errorf("exec timeout: %w", err)
 		}
 	}
 
-	return res, err
+	return c, nil
 }
 
 func (app *App) execAssert(ctx *hcl2.EvalContext, a Assert) error {
@@ -608,7 +1051,7 @@ func (app *App) RunTests(pat string) (*Result, error) {
 		})
 	}
 
-	main := testing.MainStart(TestDeps{}, suite, nil, nil)
+	main := testing.MainStart(TestDeps{}, suite, nil, nil, nil)
 
 	if err := flag.Set("test.run", rewrite(pat)); err != nil {
 		panic(err)
@@ -771,7 +1214,7 @@ func (app *App) execTestCase(t Test, c Case) (*Result, error) {
 
 	jobCtx.execMatcher.expectedExecs = expectedExecs
 
-	res, err := app.runJobAndUpdateContext(nil, jobCtx, eitherJobRun{static: &t.Run}, new(sync.Mutex), true)
+	res, err := app.runJobAndUpdateContext(context.Background(), nil, jobCtx, eitherJobRun{static: &t.Run}, new(sync.Mutex), true)
 
 	if res == nil && err != nil {
 		return nil, err
@@ -813,7 +1256,18 @@ type Result struct {
 	// Validated is set to true when and only when the command execution was successfully validated against the mock
 	Validated bool
 
+	// TimedOut is set to true when and only when the command has been terminated due to timeout.
+	// ExitStatus is set to TimeoutExitStatus in that case.
+	TimedOut bool
+
 	ExitStatus int
+
+	// Steps is the status table of the steps of the job, in the order of definitions
+	Steps []StepStatus
+
+	// Outputs is the values of the `output` blocks of the job, keyed by their names.
+	// For the job without `output` blocks, it is the outputs written by the command to the file at VARIANT_OUTPUT
+	Outputs map[string]cty.Value
 }
 
 func (res *Result) toCty() cty.Value {
@@ -822,6 +1276,10 @@ func (res *Result) toCty() cty.Value {
 			"stdout":     cty.StringVal("<not set>"),
 			"stderr":     cty.StringVal("<not set>>"),
 			"exitstatus": cty.NumberIntVal(int64(-127)),
+			"timedout":   cty.False,
+			"skipped":    cty.False,
+			"steps":      stepStatusesToCty(nil),
+			"outputs":    cty.EmptyObjectVal,
 			"set":        cty.BoolVal(false),
 		})
 	}
@@ -830,11 +1288,15 @@ func (res *Result) toCty() cty.Value {
 		"stdout":     cty.StringVal(res.Stdout),
 		"stderr":     cty.StringVal(res.Stderr),
 		"exitstatus": cty.NumberIntVal(int64(res.ExitStatus)),
+		"timedout":   cty.BoolVal(res.TimedOut),
+		"skipped":    cty.BoolVal(res.Skipped),
+		"steps":      stepStatusesToCty(res.Steps),
+		"outputs":    outputsToCty(res.Outputs),
 		"set":        cty.BoolVal(true),
 	})
 }
 
-func (app *App) dispatchRunJob(l *EventLogger, jobCtx *JobContext, run eitherJobRun, streamOutput bool) (*Result, error) {
+func (app *App) dispatchRunJob(ctx context.Context, l *EventLogger, jobCtx *JobContext, run eitherJobRun, streamOutput bool) (*Result, error) {
 	var jobRun *jobRun
 
 	var err error
@@ -871,7 +1333,7 @@ func (app *App) dispatchRunJob(l *EventLogger, jobCtx *JobContext, run eitherJob
 		}
 	}
 
-	return app.run(jobCtx, l, jobRun.Name, jobRun.Args, streamOutput)
+	return app.run(ctx, jobCtx, l, jobRun.Name, jobRun.Args, streamOutput)
 }
 
 func cloneEvalContext(c *hcl2.EvalContext) *hcl2.EvalContext {
@@ -892,7 +1354,7 @@ func cloneEvalContext(c *hcl2.EvalContext) *hcl2.EvalContext {
 	return &ctx
 }
 
-func (app *App) execMultiRun(l *EventLogger, jobCtx *JobContext, r *DependsOn, streamOutput bool) (*Result, error) {
+func (app *App) execMultiRun(ctx context.Context, l *EventLogger, jobCtx *JobContext, r *DependsOn, concurrency int, streamOutput bool) (*Result, error) {
 	ctyItems := []cty.Value{}
 
 	items := []interface{}{}
@@ -913,25 +1375,65 @@ func (app *App) execMultiRun(l *EventLogger, jobCtx *JobContext, r *DependsOn, s
 	}
 
 	if len(items) > 0 {
-		var stdout string
+		rs := make([]*Result, len(items))
+		errs := make([]error, len(items))
 
-		for _, item := range items {
-			v, err := goToCty(item)
-			if err != nil {
-				return nil, err
-			}
+		var (
+			wg     sync.WaitGroup
+			mu     sync.Mutex
+			failed bool
+		)
 
-			args, err := buildArgsFromExpr(jobCtx.WithVariable("item", v).Ptr(), r.Args)
-			if err != nil {
-				return nil, err
+		workqueue := make(chan int)
+
+		for c := 0; c < concurrency; c++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				for i := range workqueue {
+					mu.Lock()
+					skip := failed || ctx.Err() != nil
+					mu.Unlock()
+
+					// Do not start remaining items once any of them has failed, as we did when items were run sequentially
+					if skip {
+						continue
+					}
+
+					res, err := app.runItem(ctx, l, jobCtx, r, items[i], streamOutput)
+
+					mu.Lock()
+					rs[i], errs[i] = res, err
+					if err != nil {
+						failed = true
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+
+		for i := range items {
+			workqueue <- i
+		}
+
+		close(workqueue)
+
+		wg.Wait()
+
+		var stdout string
+
+		for i := range items {
+			if errs[i] != nil {
+				return rs[i], errs[i]
 			}
 
-			res, err := app.run(jobCtx, l, r.Name, args, streamOutput)
-			if err != nil {
-				return res, err
+			if rs[i] == nil {
+				return nil, xerrors.Errorf("running %q: %w", r.Name, ctx.Err())
 			}
 
-			stdout += res.Stdout + "\n"
+			stdout += rs[i].Stdout + "\n"
 		}
 
 		return &Result{
@@ -947,7 +1449,7 @@ func (app *App) execMultiRun(l *EventLogger, jobCtx *JobContext, r *DependsOn, s
 		return nil, err
 	}
 
-	res, err := app.run(jobCtx, l, r.Name, args, streamOutput)
+	res, err := app.run(ctx, jobCtx, l, r.Name, args, streamOutput)
 	if err != nil {
 		return res, err
 	}
@@ -957,8 +1459,48 @@ func (app *App) execMultiRun(l *EventLogger, jobCtx *JobContext, r *DependsOn, s
 	return res, nil
 }
 
-func (app *App) runJobAndUpdateContext(l *EventLogger, jobCtx *JobContext, run eitherJobRun, m sync.Locker, streamOutput bool) (*Result, error) {
-	res, err := app.dispatchRunJob(l, jobCtx, run, streamOutput)
+func (app *App) runItem(ctx context.Context, l *EventLogger, jobCtx *JobContext, r *DependsOn, item interface{}, streamOutput bool) (*Result, error) {
+	v, err := goToCty(item)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := buildArgsFromExpr(jobCtx.WithVariable("item", v).Ptr(), r.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.run(ctx, jobCtx, l, r.Name, args, streamOutput)
+}
+
+func (app *App) runJobAndUpdateContext(ctx context.Context, l *EventLogger, jobCtx *JobContext, run eitherJobRun, m sync.Locker, streamOutput bool) (*Result, error) {
+	retry := run.retry
+
+	onRetry := func(attempt int, delay time.Duration, lastErr error) error {
+		if l == nil {
+			return nil
+		}
+
+		var job string
+
+		if run.static != nil {
+			job = run.static.Name
+		} else {
+			job = run.dynamic.Job
+		}
+
+		return l.LogRetry(&RunEvent{Job: job, Args: map[string]interface{}{}}, nil, attempt, delay, lastErr)
+	}
+
+	res, err := withRetry(ctx, retry, jobCtx.evalContext, onRetry, func(attempt int) (*Result, error) {
+		attemptCtx := jobCtx
+
+		if retry != nil {
+			attemptCtx = jobCtx.WithVariable("retry", retryVal(attempt, nil, nil)).Ptr()
+		}
+
+		return app.dispatchRunJob(ctx, l, attemptCtx, run, streamOutput)
+	})
 
 	if res == nil {
 		res = &Result{ExitStatus: 1, Stderr: err.Error()}
@@ -984,7 +1526,7 @@ func (app *App) runJobAndUpdateContext(l *EventLogger, jobCtx *JobContext, run e
 	return res, err
 }
 
-func (app *App) execJobSteps(l *EventLogger, jobCtx *JobContext, results map[string]cty.Value, steps []Step, concurrency int, streamOutput bool) (*Result, error) {
+func (app *App) execJobSteps(ctx context.Context, l *EventLogger, jobCtx *JobContext, results map[string]cty.Value, steps []Step, concurrency int, onFailure string, runState *runState, streamOutput bool) (*Result, error) {
 	stepEvalCtx := *jobCtx.evalContext
 
 	vars := map[string]cty.Value{}
@@ -1008,37 +1550,154 @@ func (app *App) execJobSteps(l *EventLogger, jobCtx *JobContext, results map[str
 
 	var lastRes *Result
 
-	for i := range steps {
-		s := steps[i]
+	// Results of for_each and matrix steps keyed by step names and then instance keys
+	instanceResults := map[string]map[string]cty.Value{}
 
-		f := func() (*Result, error) {
-			res, err := app.runJobAndUpdateContext(l, &stepCtx, eitherJobRun{static: &s.Run}, m, streamOutput)
-			if err != nil {
-				return res, xerrors.Errorf("step %q: %w", s.Name, err)
+	newStepFunc := func(id string, s Step, inst *stepInstance) func() (*Result, error) {
+		return func() (*Result, error) {
+			name := fmt.Sprintf("%q", s.Name)
+			nodeCtx := &stepCtx
+
+			if inst != nil {
+				name = fmt.Sprintf("%q[%q]", s.Name, inst.key)
+
+				m.Lock()
+				evalCtx := cloneEvalContext(stepCtx.evalContext)
+				for k, v := range inst.vars {
+					evalCtx.Variables[k] = v
+				}
+				nodeCtx = stepCtx.WithEvalContext(evalCtx).Ptr()
+				m.Unlock()
+			}
+
+			nodeCtx, flushOutput := app.withStepOutput(nodeCtx, id)
+
+			var (
+				res      *Result
+				stepErr  error
+				restored bool
+			)
+
+			// Steps completed in the run being resumed are not run again
+			if runState != nil {
+				res, restored = runState.completed(id)
+			}
+
+			if !restored {
+				skipped, err := app.stepSkipped(l, nodeCtx.evalContext, s, m)
+				if err != nil {
+					return nil, xerrors.Errorf("step %s: %w", name, err)
+				}
+
+				if skipped {
+					res = &Result{Skipped: true}
+				} else {
+					res, err = app.runLockedStep(ctx, l, nodeCtx, s, m, streamOutput)
+					if err != nil {
+						// The result of the failed step is still recorded, so that it is available to
+						// `finally` and the steps depending on it when `continue_on_error` is set
+						stepErr = xerrors.Errorf("step %s: %w", name, err)
+					}
+				}
+			}
+
+			if err := flushOutput(); err != nil && stepErr == nil {
+				stepErr = xerrors.Errorf("step %s: writing output: %w", name, err)
 			}
 
 			m.Lock()
 
-			results[s.Name] = res.toCty()
+			if inst != nil {
+				instanceResults[s.Name][inst.key] = res.toCty()
+				results[s.Name] = cty.ObjectVal(instanceResults[s.Name])
+			} else {
+				results[s.Name] = res.toCty()
+			}
+
 			resultsCty := cty.ObjectVal(results)
 			stepEvalCtx.Variables["step"] = resultsCty
 
 			m.Unlock()
 
-			return res, nil
+			if runState != nil && !restored && stepErr == nil {
+				if err := runState.checkpoint(id, res); err != nil {
+					return res, xerrors.Errorf("step %s: checkpointing: %w", name, err)
+				}
+			}
+
+			return res, stepErr
 		}
+	}
+
+	nodeIDToContinueOnError := map[string]bool{}
+
+	// stepToNodeIDs maps each step name to the DAG nodes, which are more than one for a for_each or matrix step
+	stepToNodeIDs := map[string][]string{}
 
-		idToF[s.Name] = f
+	nodeIDToNeeds := map[string][]string{}
 
-		dagNodeIDToIndex[s.Name] = i
+	for i := range steps {
+		s := steps[i]
 
-		dagNodeIds = append(dagNodeIds, s.Name)
+		var needs []string
 
 		if s.Needs != nil {
-			dagNodeIDToDeps[s.Name] = *s.Needs
-		} else {
-			dagNodeIDToDeps[s.Name] = []string{}
+			needs = *s.Needs
+		}
+
+		continueOnError := s.ContinueOnError != nil && *s.ContinueOnError
+
+		if IsExpressionEmpty(s.ForEach) && s.Matrix == nil {
+			idToF[s.Name] = newStepFunc(s.Name, s, nil)
+			dagNodeIDToIndex[s.Name] = len(dagNodeIds)
+			dagNodeIds = append(dagNodeIds, s.Name)
+			stepToNodeIDs[s.Name] = []string{s.Name}
+			nodeIDToNeeds[s.Name] = needs
+			nodeIDToContinueOnError[s.Name] = continueOnError
+
+			continue
+		}
+
+		instances, err := expandStep(s, &stepEvalCtx)
+		if err != nil {
+			return nil, xerrors.Errorf("step %q: %w", s.Name, err)
+		}
+
+		instanceResults[s.Name] = map[string]cty.Value{}
+		results[s.Name] = cty.EmptyObjectVal
+		stepToNodeIDs[s.Name] = []string{}
+
+		for j := range instances {
+			inst := instances[j]
+			id := fmt.Sprintf("%s[%q]", s.Name, inst.key)
+
+			idToF[id] = newStepFunc(id, s, &inst)
+			dagNodeIDToIndex[id] = len(dagNodeIds)
+			dagNodeIds = append(dagNodeIds, id)
+			stepToNodeIDs[s.Name] = append(stepToNodeIDs[s.Name], id)
+			nodeIDToNeeds[id] = needs
+			nodeIDToContinueOnError[id] = continueOnError
+		}
+	}
+
+	if len(instanceResults) > 0 {
+		// So that steps can refer to the results of for_each and matrix steps that expanded to nothing
+		stepEvalCtx.Variables["step"] = cty.ObjectVal(results)
+	}
+
+	for id, needs := range nodeIDToNeeds {
+		deps := []string{}
+
+		for _, n := range needs {
+			if ids, ok := stepToNodeIDs[n]; ok {
+				deps = append(deps, ids...)
+			} else {
+				// Let the DAG report the unknown dependency
+				deps = append(deps, n)
+			}
 		}
+
+		dagNodeIDToDeps[id] = deps
 	}
 
 	g := dag.New(dag.Nodes(dagNodeIds))
@@ -1052,12 +1711,7 @@ func (app *App) execJobSteps(l *EventLogger, jobCtx *JobContext, results map[str
 		return nil, xerrors.Errorf("calculating DAG of dependencies: %w", err)
 	}
 
-	type result struct {
-		r   *Result
-		err error
-	}
-
-	var rs []result
+	var waves [][]string
 
 	for _, nodes := range plan {
 		ids := []string{}
@@ -1069,6 +1723,36 @@ func (app *App) execJobSteps(l *EventLogger, jobCtx *JobContext, results map[str
 			return dagNodeIDToIndex[ids[i]] < dagNodeIDToIndex[ids[j]]
 		})
 
+		waves = append(waves, ids)
+	}
+
+	if jobCtx.execMatcher != nil && jobCtx.execMatcher.record {
+		app.printDryRunWaves(jobCtx.execMatcher.out, waves)
+	}
+
+	type result struct {
+		r   *Result
+		err error
+	}
+
+	var (
+		rs   []result
+		rese *multierror.Error
+
+		// The result of the first failed step is returned along with the error, so that
+		// e.g. the exit status and the timeout of the failed step is visible to the caller
+		failedRes *Result
+
+		// failed contains steps that have failed or have not run due to failures, so that
+		// steps depending on them are not run
+		failed = map[string]bool{}
+
+		statuses = map[string]StepStatus{}
+
+		ctxErr error
+	)
+
+	for _, ids := range waves {
 		var wg sync.WaitGroup
 
 		rs = make([]result, len(ids))
@@ -1098,8 +1782,14 @@ func (app *App) execJobSteps(l *EventLogger, jobCtx *JobContext, results map[str
 			workqueue <- func() {
 				defer wg.Done()
 
+				var depFailed bool
+
+				for _, d := range dagNodeIDToDeps[id] {
+					depFailed = depFailed || failed[d]
+				}
+
 				rsm.Lock()
-				if cancelled {
+				if cancelled || depFailed || ctx.Err() != nil {
 					rs[ii] = result{r: &Result{Cancelled: true}}
 					rsm.Unlock()
 
@@ -1112,40 +1802,95 @@ func (app *App) execJobSteps(l *EventLogger, jobCtx *JobContext, results map[str
 				rsm.Lock()
 				defer rsm.Unlock()
 				rs[ii] = result{r: r, err: err}
-				if err != nil {
+				if err != nil && !nodeIDToContinueOnError[id] && onFailure == OnFailureFailFast {
 					cancelled = true
 				}
 			}
 		}
 
+		// Let the workers exit once all the steps in the wave have been run
+		close(workqueue)
+
 		wg.Wait()
 
 		lastRes = rs[len(rs)-1].r
 
-		var rese *multierror.Error
+		for i, id := range ids {
+			r := rs[i]
 
-		for i := range rs {
-			if e := rs[i].err; e != nil {
-				rese = multierror.Append(rese, e)
+			statuses[id] = newStepStatus(id, r.r, r.err)
+
+			switch {
+			case r.err != nil && nodeIDToContinueOnError[id]:
+				// The failure is recorded in the status table, but does not fail the job
+			case r.err != nil:
+				rese = multierror.Append(rese, r.err)
+				failed[id] = true
+
+				if failedRes == nil {
+					failedRes = r.r
+				}
+			case r.r.Cancelled:
+				failed[id] = true
 			}
 		}
 
-		if rese != nil && rese.Len() > 0 {
-			return lastRes, rese
+		if rese != nil && rese.Len() > 0 && onFailure != OnFailureContinue {
+			break
+		}
+
+		// Do not start steps in the next wave once the whole run has been cancelled
+		if err := ctx.Err(); err != nil {
+			ctxErr = xerrors.Errorf("running steps: %w", err)
+
+			break
+		}
+	}
+
+	table := make([]StepStatus, 0, len(dagNodeIds))
+
+	for _, id := range dagNodeIds {
+		st, ok := statuses[id]
+		if !ok {
+			st = newStepStatus(id, nil, nil)
 		}
+
+		table = append(table, st)
+	}
+
+	if rese != nil && rese.Len() > 0 {
+		return withStepStatuses(failedRes, table), rese
+	}
+
+	if ctxErr != nil {
+		return withStepStatuses(lastRes, table), ctxErr
 	}
 
 	if len(rs) > 0 {
 		var sum Result
 
-		sum.ExitStatus = lastRes.ExitStatus
+		// The last step can have no result when it failed before running with continue_on_error, e.g. on an invalid condition
+		if lastRes != nil {
+			sum.ExitStatus = lastRes.ExitStatus
+		}
+
+		sum.Steps = table
+
+		var n int
+
+		for _, r := range rs {
+			// Skipped steps have no output to be concatenated
+			if r.r == nil || r.r.Skipped {
+				continue
+			}
 
-		for i, r := range rs {
-			if i != 0 {
+			if n != 0 {
 				sum.Stdout += "\n"
 				sum.Stderr += "\n"
 			}
 
+			n++
+
 			sum.Stdout += r.r.Stdout
 			sum.Stderr += r.r.Stderr
 		}
@@ -1156,6 +1901,55 @@ func (app *App) execJobSteps(l *EventLogger, jobCtx *JobContext, results map[str
 	return lastRes, nil
 }
 
+// withStepStatuses returns a copy of the result with the status table of the steps.
+func withStepStatuses(res *Result, statuses []StepStatus) *Result {
+	if res == nil {
+		return nil
+	}
+
+	r := *res
+	r.Steps = statuses
+
+	return &r
+}
+
+// stepSkipped evaluates the step's `condition` against the variables including results of the previous steps,
+// and tells if the step should be skipped.
+func (app *App) stepSkipped(l *EventLogger, stepEvalCtx *hcl2.EvalContext, s Step, m sync.Locker) (bool, error) {
+	if IsExpressionEmpty(s.Condition) {
+		return false, nil
+	}
+
+	var condition bool
+
+	m.Lock()
+	diags := gohcl2.DecodeExpression(s.Condition, stepEvalCtx, &condition)
+	m.Unlock()
+
+	if diags.HasErrors() {
+		return false, diags
+	}
+
+	if condition {
+		return false, nil
+	}
+
+	if l != nil {
+		if err := l.append(Event{
+			Type: "run:skipped",
+			Time: time.Now(),
+			Run: &RunEvent{
+				Job:  s.Run.Name,
+				Args: map[string]interface{}{},
+			},
+		}); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
 func getContext(sourceLocator hcl2.Expression) cty.Value {
 	sourcedir := cty.StringVal(filepath.Dir(sourceLocator.Range().Filename))
 	context := map[string]cty.Value{}
@@ -1271,17 +2065,99 @@ type JobContext struct {
 	globalArgs map[string]interface{}
 
 	execMatcher *execMatcher
+
+	// runState is set only to the context created by RunContext, to checkpoint and resume the steps of the job
+	runState *runState
+
+	// stdin is set only to the context created by RunContextWithStdin, to be fed to the exec of the job
+	stdin io.Reader
+
+	// env is the environment variables of the job and its callers, given to every exec run by the job
+	env map[string]string
+
+	// executor runs the commands of the job and its callees. nil means App.Executor
+	executor Executor
+
+	// stdout and stderr are where the output of the commands of the step and its callees is streamed.
+	// nil means App.Stdout and App.Stderr
+	stdout io.Writer
+	stderr io.Writer
+
+	// locks are the locks held by the job and its callers, keyed by their names
+	locks map[string]*heldLock
+
+	// setOpts asks for the approvals of the job and its callees
+	setOpts SetOptsFunc
+
+	// parallelism limits the number of commands run at the same time across the whole run
+	parallelism semaphore
 }
 
 type execMatcher struct {
 	execInvocationCount int
 	expectedExecs       []expectedExec
+
+	// record is set to true in the dry-run mode, in which commands are printed instead of being executed
+	record bool
+	// out is where the jobs, the steps and the commands are printed in the dry-run mode
+	out io.Writer
+	// printExec prints the command in the dry-run mode
+	printExec func(io.Writer, Command)
+}
+
+// intercepts tells if the command should be run by the execMatcher, instead of the executor of the job.
+func (m *execMatcher) intercepts() bool {
+	return len(m.expectedExecs) > 0 || m.execInvocationCount > 0 || m.record
+}
+
+// Run validates the command against the next expectation, or prints it in the dry-run mode, without running it.
+func (m *execMatcher) Run(_ context.Context, cmd Command) (*Result, error) {
+	// If we have one ore more pending exec expectations, never run the actual command.
+	// Instead, do validate the execCmd run against the expectation.
+	if len(m.expectedExecs) > 0 {
+		m.execInvocationCount++
+
+		expectation := m.expectedExecs[0]
+
+		if cmd.Name != expectation.Command {
+			return nil, fmt.Errorf("unexpected exec %d: expected command %q, got %q", m.execInvocationCount, expectation.Command, cmd.Name)
+		}
+
+		if diff := cmp.Diff(expectation.Args, cmd.Args); diff != "" {
+			return nil, fmt.Errorf("unexpected exec %d: expected args %v, got %v", m.execInvocationCount, expectation.Args, cmd.Args)
+		}
+
+		if diff := cmp.Diff(expectation.Dir, cmd.Dir); diff != "" {
+			return nil, fmt.Errorf("unexpected exec %d: expected dir %q, got %q", m.execInvocationCount, expectation.Dir, cmd.Dir)
+		}
+
+		// Pop the successful command expectation so that on next execCmd call, we can
+		// use expectedExecs[0] as the next expectation to be checked.
+		m.expectedExecs = m.expectedExecs[1:]
+
+		return &Result{Validated: true}, nil
+	} else if m.execInvocationCount > 0 {
+		return nil, fmt.Errorf("unexpected exec %d: fix the test by adding an expect block for this exec, or fix the test target: %v", m.execInvocationCount+1, cmd)
+	}
+
+	// In the dry-run mode, never run the actual command but print it.
+	m.printExec(m.out, cmd)
+
+	return &Result{}, nil
 }
 
 func (c *JobContext) WithEvalContext(evalCtx *hcl2.EvalContext) JobContext {
 	return JobContext{
 		evalContext: evalCtx,
 		globalArgs:  c.globalArgs,
+		execMatcher: c.execMatcher,
+		env:         c.env,
+		executor:    c.executor,
+		stdout:      c.stdout,
+		stderr:      c.stderr,
+		locks:       c.locks,
+		setOpts:     c.setOpts,
+		parallelism: c.parallelism,
 	}
 }
 
@@ -1297,15 +2173,15 @@ func (c JobContext) Ptr() *JobContext {
 	return &c
 }
 
-func (app *App) createJobContext(cc *HCL2Config, j JobSpec, givenParams map[string]interface{}, givenOpts map[string]interface{}, f SetOptsFunc) (*JobContext, error) {
-	ctx := getContext(j.SourceLocator)
+func (app *App) createJobContext(ctx context.Context, cc *HCL2Config, j JobSpec, givenParams map[string]interface{}, givenOpts map[string]interface{}, f SetOptsFunc) (*JobContext, error) {
+	sourceCtx := getContext(j.SourceLocator)
 
-	globalParams, err := setParameterValues("global parameter", ctx, cc.Parameters, givenParams)
+	globalParams, err := setParameterValues("global parameter", sourceCtx, cc.Parameters, givenParams)
 	if err != nil {
 		return nil, err
 	}
 
-	localParams, err := setParameterValues("parameter", ctx, j.Parameters, givenParams)
+	localParams, err := setParameterValues("parameter", sourceCtx, j.Parameters, givenParams)
 	if err != nil {
 		return nil, err
 	}
@@ -1327,12 +2203,12 @@ func (app *App) createJobContext(cc *HCL2Config, j JobSpec, givenParams map[stri
 		}
 	}
 
-	globalOpts, err := setOptionValues("global option", ctx, cc.Options, givenOpts, f)
+	globalOpts, err := setOptionValues("global option", sourceCtx, cc.Options, givenOpts, f)
 	if err != nil {
 		return nil, err
 	}
 
-	localOpts, err := setOptionValues("option", ctx, j.Options, givenOpts, f)
+	localOpts, err := setOptionValues("option", sourceCtx, j.Options, givenOpts, f)
 	if err != nil {
 		return nil, err
 	}
@@ -1343,6 +2219,20 @@ func (app *App) createJobContext(cc *HCL2Config, j JobSpec, givenParams map[stri
 		opts[k] = v
 	}
 
+	for _, specs := range [][]OptionSpec{cc.Options, j.Options} {
+		for _, o := range specs {
+			if o.Sensitive != nil && *o.Sensitive {
+				if v, ok := globalOpts[o.Name]; ok {
+					app.addSecrets(v)
+				}
+
+				if v, ok := localOpts[o.Name]; ok {
+					app.addSecrets(v)
+				}
+			}
+		}
+	}
+
 	// In case this is not a default/root job, we have a separate set of options to override the globals. So:
 	if j.Name != "" {
 		for k, v := range localOpts {
@@ -1367,7 +2257,7 @@ func (app *App) createJobContext(cc *HCL2Config, j JobSpec, givenParams map[stri
 		Variables: map[string]cty.Value{
 			"param":   cty.ObjectVal(params),
 			"opt":     cty.ObjectVal(opts),
-			"context": ctx,
+			"context": sourceCtx,
 		},
 	}
 
@@ -1425,7 +2315,7 @@ func (app *App) createJobContext(cc *HCL2Config, j JobSpec, givenParams map[stri
 		return secretRefsEvaluator.Eval(m)
 	}
 
-	updatedContext, err := app.addConfigsAndVariables(confJobCtx, varSpecs, configs, secrets, g)
+	updatedContext, err := app.addConfigsAndVariables(ctx, confJobCtx, varSpecs, configs, secrets, g)
 	if err != nil {
 		return nil, err
 	}
@@ -1436,7 +2326,7 @@ func (app *App) createJobContext(cc *HCL2Config, j JobSpec, givenParams map[stri
 }
 
 //nolint:unused
-func (app *App) getConfigs(jobCtx *JobContext, confType string, confSpecs []Config, g func(map[string]interface{}) (map[string]interface{}, error)) (cty.Value, error) {
+func (app *App) getConfigs(ctx context.Context, jobCtx *JobContext, confType string, confSpecs []Config, g func(map[string]interface{}) (map[string]interface{}, error)) (cty.Value, error) {
 	confCtx := jobCtx.evalContext
 
 	confFields := map[string]cty.Value{}
@@ -1444,7 +2334,7 @@ func (app *App) getConfigs(jobCtx *JobContext, confType string, confSpecs []Conf
 	for confIndex := range confSpecs {
 		confSpec := confSpecs[confIndex]
 
-		v, err := app.evaluateConfig(jobCtx, confType, confSpec, confCtx, g)
+		v, err := app.evaluateConfig(ctx, jobCtx, confType, confSpec, confCtx, g)
 		if err != nil {
 			return cty.DynamicVal, err
 		}
@@ -1455,13 +2345,13 @@ func (app *App) getConfigs(jobCtx *JobContext, confType string, confSpecs []Conf
 	return cty.ObjectVal(confFields), nil
 }
 
-func (app *App) evaluateConfig(jobCtx *JobContext, confType string, confSpec Config, confCtx *hcl2.EvalContext, g func(map[string]interface{}) (map[string]interface{}, error)) (cty.Value, error) {
+func (app *App) evaluateConfig(ctx context.Context, jobCtx *JobContext, confType string, confSpec Config, confCtx *hcl2.EvalContext, g func(map[string]interface{}) (map[string]interface{}, error)) (cty.Value, error) {
 	merged := map[string]interface{}{}
 
 	for sourceIdx := range confSpec.Sources {
 		sourceSpec := confSpec.Sources[sourceIdx]
 
-		fragments, err := app.loadConfigSource(jobCtx, confCtx, sourceSpec)
+		fragments, err := app.loadConfigSource(ctx, jobCtx, confCtx, sourceSpec)
 		if err != nil {
 			return cty.DynamicVal, xerrors.Errorf("%s %q: source %d: %w", confType, confSpec.Name, sourceIdx, err)
 		}
@@ -1535,15 +2425,15 @@ func (app *App) evaluateConfig(jobCtx *JobContext, confType string, confSpec Con
 }
 
 //nolint:unused
-func (app *App) addConfigsAndVariablesDeprecated(jobCtx *JobContext, varSpecs []Variable, configs []Config, secrets []Config, g func(m map[string]interface{}) (map[string]interface{}, error)) (*hcl2.EvalContext, error) {
-	conf, err := app.getConfigs(jobCtx, "config", configs, nil)
+func (app *App) addConfigsAndVariablesDeprecated(ctx context.Context, jobCtx *JobContext, varSpecs []Variable, configs []Config, secrets []Config, g func(m map[string]interface{}) (map[string]interface{}, error)) (*hcl2.EvalContext, error) {
+	conf, err := app.getConfigs(ctx, jobCtx, "config", configs, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	secJobCtx := jobCtx.WithVariable("conf", conf).Ptr()
 
-	sec, err := app.getConfigs(secJobCtx, "secret", secrets, g)
+	sec, err := app.getConfigs(ctx, secJobCtx, "secret", secrets, g)
 	if err != nil {
 		return nil, err
 	}
@@ -1559,8 +2449,8 @@ func (app *App) addConfigsAndVariablesDeprecated(jobCtx *JobContext, varSpecs []
 }
 
 //nolint:gocyclo
-func (app *App) addConfigsAndVariables(jobCtx *JobContext, varSpecs []Variable, confSpecs []Config, secSpecs []Config, g func(m map[string]interface{}) (map[string]interface{}, error)) (*hcl2.EvalContext, error) {
-	ctx := jobCtx.evalContext
+func (app *App) addConfigsAndVariables(ctx context.Context, jobCtx *JobContext, varSpecs []Variable, confSpecs []Config, secSpecs []Config, g func(m map[string]interface{}) (map[string]interface{}, error)) (*hcl2.EvalContext, error) {
+	evalCtx := jobCtx.evalContext
 
 	type node struct {
 		config   *Config
@@ -1703,9 +2593,9 @@ func (app *App) addConfigsAndVariables(jobCtx *JobContext, varSpecs []Variable,
 
 	//nolint:nestif
 	for _, wave := range top {
-		ctx.Variables["var"] = cty.ObjectVal(varFields)
-		ctx.Variables["conf"] = cty.ObjectVal(confFields)
-		ctx.Variables["sec"] = cty.ObjectVal(secFields)
+		evalCtx.Variables["var"] = cty.ObjectVal(varFields)
+		evalCtx.Variables["conf"] = cty.ObjectVal(confFields)
+		evalCtx.Variables["sec"] = cty.ObjectVal(secFields)
 
 		for _, info := range wave {
 			node, ok := nodes[info.Id]
@@ -1714,21 +2604,23 @@ func (app *App) addConfigsAndVariables(jobCtx *JobContext, varSpecs []Variable,
 			}
 
 			if v := node.config; v != nil {
-				r, err := app.evaluateConfig(jobCtx, "config", *v, ctx, nil)
+				r, err := app.evaluateConfig(ctx, jobCtx, "config", *v, evalCtx, nil)
 				if err != nil {
 					return nil, err
 				}
 
 				confFields[v.Name] = r
 			} else if v := node.secret; v != nil {
-				r, err := app.evaluateConfig(jobCtx, "secret", *v, ctx, g)
+				r, err := app.evaluateConfig(ctx, jobCtx, "secret", *v, evalCtx, g)
 				if err != nil {
 					return nil, err
 				}
 
+				app.addSecrets(r)
+
 				secFields[v.Name] = r
 			} else if v := node.variable; v != nil {
-				r, err := evaluateVariable(ctx, *v)
+				r, err := evaluateVariable(evalCtx, *v)
 				if err != nil {
 					return nil, err
 				}
@@ -1740,11 +2632,11 @@ func (app *App) addConfigsAndVariables(jobCtx *JobContext, varSpecs []Variable,
 		}
 	}
 
-	ctx.Variables["var"] = cty.ObjectVal(varFields)
-	ctx.Variables["conf"] = cty.ObjectVal(confFields)
-	ctx.Variables["sec"] = cty.ObjectVal(secFields)
+	evalCtx.Variables["var"] = cty.ObjectVal(varFields)
+	evalCtx.Variables["conf"] = cty.ObjectVal(confFields)
+	evalCtx.Variables["sec"] = cty.ObjectVal(secFields)
 
-	return ctx, nil
+	return evalCtx, nil
 }
 
 func setVariables(varCtx *hcl2.EvalContext, varSpecs []Variable) (*hcl2.EvalContext, error) {
@@ -1874,3 +2766,19 @@ func getModule(ctx *hcl2.EvalContext, m1, m2 hcl2.Expression) (cty.Value, error)
 func IsExpressionEmpty(ex hcl2.Expression) bool {
 	return !nonEmptyExpression(ex)
 }
+
+// decodeDuration decodes the expression into a duration string like "5m" and parses it.
+func decodeDuration(expr hcl2.Expression, ctx *hcl2.EvalContext) (time.Duration, error) {
+	var s string
+
+	if diags := gohcl2.DecodeExpression(expr, ctx, &s); diags.HasErrors() {
+		return 0, diags
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, xerrors.Errorf("parsing duration %q: %w", s, err)
+	}
+
+	return d, nil
+}
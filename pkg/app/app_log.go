@@ -1,13 +1,14 @@
 package app
 
 import (
+	"context"
 	"fmt"
 
 	gohcl2 "github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/zclconf/go-cty/cty"
 )
 
-func (app *App) newLogCollector(file string, j JobSpec, jobCtx *JobContext) LogCollector {
+func (app *App) newLogCollector(ctx context.Context, file string, j JobSpec, jobCtx *JobContext) LogCollector {
 	logCollector := LogCollector{
 		FilePath: file,
 		CollectFn: func(evt Event) (*string, bool, error) {
@@ -75,7 +76,7 @@ func (app *App) newLogCollector(file string, j JobSpec, jobCtx *JobContext) LogC
 			newJobCtx.evalContext = &evalCtx
 
 			for _, f := range j.Log.Forwards {
-				_, err := app.dispatchRunJob(nil, &newJobCtx, eitherJobRun{static: f.Run}, false)
+				_, err := app.dispatchRunJob(ctx, nil, &newJobCtx, eitherJobRun{static: f.Run}, false)
 				if err != nil {
 					return err
 				}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -39,6 +40,7 @@ func (app *App) ExportBinary(srcDir, dstFile string) error {
 	}
 
 	_, err = app.execCmd(
+		context.Background(),
 		nil,
 		Command{
 			Name: "sh",
@@ -148,6 +150,7 @@ func main() {
 	}
 
 	_, err = app.execCmd(
+		context.Background(),
 		nil,
 		Command{
 			Name: "sh",
@@ -165,6 +168,7 @@ func main() {
 	}
 
 	_, err = app.execCmd(
+		context.Background(),
 		nil,
 		Command{
 			Name: "sh",
@@ -180,6 +184,7 @@ func main() {
 	variantReplace := os.Getenv("VARIANT_BUILD_VARIANT_REPLACE")
 	if variantReplace != "" {
 		_, err = app.execCmd(
+			context.Background(),
 			nil,
 			Command{
 				Name: "sh",
@@ -201,6 +206,7 @@ func main() {
 
 	for _, modReplace := range modReplaces {
 		_, err = app.execCmd(
+			context.Background(),
 			nil,
 			Command{
 				Name: "sh",
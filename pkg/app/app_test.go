@@ -1,9 +1,18 @@
 package app
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/zclconf/go-cty/cty"
 )
 
 func TestExampleComplex(t *testing.T) {
@@ -76,3 +85,1403 @@ func TestExampleComplex(t *testing.T) {
 		})
 	}
 }
+
+func TestRunContextCancellation(t *testing.T) {
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "sleep" {
+  exec {
+    command = "bash"
+    args = ["-c", "sleep 30 & wait"]
+  }
+}
+
+job "test" {
+  step "sleep" {
+    run "sleep" {
+    }
+  }
+
+  step "next" {
+    run "sleep" {
+    }
+    need = ["sleep"]
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Stdout = os.Stdout
+	app.Stderr = os.Stderr
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err = app.RunContext(ctx, "test", map[string]interface{}{}, map[string]interface{}{})
+	if err == nil {
+		t.Fatal("expected error did not occur")
+	}
+
+	if !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("unexpected error: want %q to be contained, got %v", context.DeadlineExceeded, err)
+	}
+
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("cancellation took too long: %v", d)
+	}
+}
+
+func TestFinallyRunsAfterCancellation(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "cleaned-up")
+
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "sleep" {
+  exec {
+    command = "bash"
+    args = ["-c", "sleep 30 & wait"]
+  }
+}
+
+job "cleanup" {
+  option "message" {
+    type = string
+  }
+
+  exec {
+    command = "bash"
+    args = ["-c", "echo \"${opt.message}\" > ` + marker + `"]
+  }
+}
+
+job "test" {
+  step "sleep" {
+    run "sleep" {
+    }
+  }
+
+  finally {
+    run "cleanup" {
+      message = run.err != "" ? "failed" : "succeeded"
+    }
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Stdout = os.Stdout
+	app.Stderr = os.Stderr
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	if _, err := app.RunContext(ctx, "test", map[string]interface{}{}, map[string]interface{}{}); err == nil {
+		t.Fatal("expected error did not occur")
+	}
+
+	got, err := ioutil.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("finally did not run: %v", err)
+	}
+
+	if s := strings.TrimSpace(string(got)); s != "failed" {
+		t.Errorf("unexpected message: want %q, got %q", "failed", s)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "touched")
+
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "touch" {
+  option "name" {
+    type = string
+  }
+
+  exec {
+    command = "touch"
+    args = ["` + marker + `"]
+    dir = "/tmp"
+    env = {
+      NAME = opt.name
+    }
+  }
+}
+
+job "deploy" {
+  option "env" {
+    type = string
+  }
+
+  step "a" {
+    run "touch" {
+      name = "a-${opt.env}"
+    }
+  }
+
+  step "b" {
+    run "touch" {
+      name = "b-${opt.env}"
+    }
+  }
+
+  step "c" {
+    run "touch" {
+      name = "c-${opt.env}"
+    }
+    need = ["a", "b"]
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+
+	app.Stdout = &stdout
+	app.Stderr = os.Stderr
+	app.DryRun = true
+
+	if _, err := app.Run("deploy", map[string]interface{}{}, map[string]interface{}{"env": "prod"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `job "deploy"
+  wave 1: a, b
+  wave 2: c
+job "touch"
+  exec: "touch" "` + marker + `"
+    dir: /tmp
+    env: NAME=a-prod
+job "touch"
+  exec: "touch" "` + marker + `"
+    dir: /tmp
+    env: NAME=b-prod
+job "touch"
+  exec: "touch" "` + marker + `"
+    dir: /tmp
+    env: NAME=c-prod
+`
+
+	if got := stdout.String(); got != want {
+		t.Errorf("unexpected output: want\n%s\ngot\n%s", want, got)
+	}
+
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("command must not be run in the dry-run mode: %v", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	fail := filepath.Join(dir, "fail")
+
+	if err := ioutil.WriteFile(fail, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "echo" {
+  option "message" {
+    type = string
+  }
+
+  exec {
+    command = "bash"
+    args = ["-c", "echo ${opt.message} >> ` + log + `; if [ -e ` + fail + ` ] && [ ${opt.message} != one ]; then exit 1; fi; echo ${opt.message}"]
+  }
+}
+
+job "rollout" {
+  step "one" {
+    run "echo" {
+      message = "one"
+    }
+  }
+
+  step "two" {
+    run "echo" {
+      message = "two-${trimspace(step.one.stdout)}"
+    }
+    need = ["one"]
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+
+	app.Stdout = os.Stdout
+	app.Stderr = &stderr
+	app.StateDir = filepath.Join(dir, "state")
+
+	if _, err := app.Run("rollout", map[string]interface{}{}, map[string]interface{}{}); err == nil {
+		t.Fatal("expected error did not occur")
+	}
+
+	files, err := ioutil.ReadDir(app.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Fatalf("unexpected number of run-state files: want 1, got %d", len(files))
+	}
+
+	runID := strings.TrimSuffix(files[0].Name(), ".json")
+
+	if !strings.Contains(stderr.String(), "--resume "+runID) {
+		t.Errorf("how to resume the run is not printed: %s", stderr.String())
+	}
+
+	if err := os.Remove(fail); err != nil {
+		t.Fatal(err)
+	}
+
+	app.Resume = runID
+
+	res, err := app.Run("rollout", map[string]interface{}{}, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := strings.TrimSpace(res.Stdout); got != "two-one" {
+		t.Errorf("unexpected stdout: want %q, got %q", "two-one", got)
+	}
+
+	got, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The step "one" completed in the first run must not be run again
+	if want := "one\ntwo-one\ntwo-one\n"; string(got) != want {
+		t.Errorf("unexpected runs: want %q, got %q", want, string(got))
+	}
+
+	if _, err := os.Stat(filepath.Join(app.StateDir, runID+".json")); !os.IsNotExist(err) {
+		t.Errorf("run-state file must be removed once the run succeeded: %v", err)
+	}
+}
+
+func TestResumeWithDifferentArgs(t *testing.T) {
+	dir := t.TempDir()
+
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "deploy" {
+  option "token" {
+    type = string
+    sensitive = true
+  }
+
+  step "login" {
+    run "sh" {
+      script = "echo token=${opt.token}"
+    }
+  }
+
+  step "push" {
+    run "sh" {
+      script = "exit 1"
+    }
+    need = ["login"]
+  }
+}
+
+job "sh" {
+  option "script" {
+    type = string
+  }
+
+  exec {
+    command = "sh"
+    args = ["-c", opt.script]
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Stdout = &bytes.Buffer{}
+	app.Stderr = &bytes.Buffer{}
+	app.StateDir = filepath.Join(dir, "state")
+
+	if _, err := app.Run("deploy", map[string]interface{}{}, map[string]interface{}{"token": "s3cr3t"}); err == nil {
+		t.Fatal("expected error did not occur")
+	}
+
+	files, err := ioutil.ReadDir(app.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Fatalf("unexpected number of run-state files: want 1, got %d", len(files))
+	}
+
+	app.Resume = strings.TrimSuffix(files[0].Name(), ".json")
+
+	_, err = app.Run("deploy", map[string]interface{}{}, map[string]interface{}{"token": "other"})
+	if err == nil {
+		t.Fatal("expected error did not occur")
+	}
+
+	if want := "with different parameters or options"; !strings.Contains(err.Error(), want) {
+		t.Errorf("unexpected error: want %q to be contained in %q", want, err.Error())
+	}
+}
+
+func TestPersistedResultsWithSecrets(t *testing.T) {
+	dir := t.TempDir()
+	fail := filepath.Join(dir, "fail")
+	out := filepath.Join(dir, "out")
+
+	if err := ioutil.WriteFile(fail, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "deploy" {
+  option "token" {
+    type = string
+    sensitive = true
+  }
+
+  step "login" {
+    run "login" {
+      token = opt.token
+    }
+  }
+
+  step "push" {
+    run "sh" {
+      script = "if [ -e ` + fail + ` ]; then exit 1; fi; echo ${trimspace(step.login.stdout)} > ` + out + `"
+    }
+    need = ["login"]
+  }
+}
+
+job "login" {
+  option "token" {
+    type = string
+  }
+
+  cache {}
+
+  exec {
+    command = "echo"
+    args = ["token=${opt.token}"]
+  }
+}
+
+job "sh" {
+  option "script" {
+    type = string
+  }
+
+  exec {
+    command = "sh"
+    args = ["-c", opt.script]
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+
+	app.Stdout = stdout
+	app.Stderr = &bytes.Buffer{}
+	app.StateDir = filepath.Join(dir, "state")
+	app.CacheDir = filepath.Join(dir, "cache")
+
+	opts := map[string]interface{}{"token": "s3cr3t"}
+
+	if _, err := app.Run("deploy", map[string]interface{}{}, opts); err == nil {
+		t.Fatal("expected error did not occur")
+	}
+
+	files, err := ioutil.ReadDir(app.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Fatalf("unexpected number of run-state files: want 1, got %d", len(files))
+	}
+
+	// The run-state file contains the secret as it is, so that it is readable only by the user
+	if mode := files[0].Mode().Perm(); mode != 0600 {
+		t.Errorf("unexpected mode of the run-state file: want %v, got %v", os.FileMode(0600), mode)
+	}
+
+	if err := os.Remove(fail); err != nil {
+		t.Fatal(err)
+	}
+
+	assertOut := func() {
+		t.Helper()
+
+		got, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := "token=s3cr3t\n"; string(got) != want {
+			t.Errorf("unexpected output: want %q, got %q", want, string(got))
+		}
+	}
+
+	// The step "login" is restored from the run-state file
+	app.Resume = strings.TrimSuffix(files[0].Name(), ".json")
+
+	if _, err := app.Run("deploy", map[string]interface{}{}, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertOut()
+
+	if err := os.Remove(out); err != nil {
+		t.Fatal(err)
+	}
+
+	// The job "login" is restored from the result cache
+	app.Resume = ""
+
+	if _, err := app.Run("deploy", map[string]interface{}{}, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assertOut()
+
+	// Secrets are still masked when the restored results are printed
+	if strings.Contains(stdout.String(), "s3cr3t") {
+		t.Errorf("stdout contains the secret: %s", stdout.String())
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "lookup" {
+  option "cluster" {
+    type = string
+  }
+
+  option "ttl" {
+    type = string
+  }
+
+  cache {
+    key = [opt.cluster]
+    ttl = opt.ttl
+  }
+
+  exec {
+    command = "bash"
+    args = ["-c", "echo ${opt.cluster} | tee -a ` + log + `"]
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Stdout = os.Stdout
+	app.Stderr = os.Stderr
+	app.CacheDir = filepath.Join(dir, "cache")
+
+	run := func(cluster, ttl string) {
+		t.Helper()
+
+		res, err := app.Run("lookup", map[string]interface{}{}, map[string]interface{}{"cluster": cluster, "ttl": ttl})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if got := strings.TrimSpace(res.Stdout); got != cluster {
+			t.Errorf("unexpected stdout: want %q, got %q", cluster, got)
+		}
+	}
+
+	run("a", "1h")
+	run("a", "1h")
+	run("b", "1h")
+	run("c", "1ms")
+
+	time.Sleep(10 * time.Millisecond)
+
+	run("c", "1ms")
+
+	got, err := ioutil.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "a" is cached, and "c" is run again as its cache has expired
+	if want := "a\nb\nc\nc\n"; string(got) != want {
+		t.Errorf("unexpected runs: want %q, got %q", want, string(got))
+	}
+
+	// A broken result is listed and cleared instead of failing them
+	if err := ioutil.WriteFile(filepath.Join(app.resultCacheDir(), "broken.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := app.ListCachedResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("unexpected number of cached results: want 4, got %d", len(results))
+	}
+
+	var broken int
+
+	for _, r := range results {
+		if r.Broken {
+			broken++
+		}
+	}
+
+	if broken != 1 {
+		t.Errorf("unexpected number of broken results: want 1, got %d", broken)
+	}
+
+	n, err := app.ClearCachedResults("lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 4 {
+		t.Errorf("unexpected number of removed results: want 4, got %d", n)
+	}
+
+	run("a", "1h")
+
+	if got, _ := ioutil.ReadFile(log); !strings.HasSuffix(string(got), "c\na\n") {
+		t.Errorf("job must be run again after the cache is cleared: %q", string(got))
+	}
+}
+
+func TestInputsAndOutputs(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	out := filepath.Join(dir, "out")
+	log := filepath.Join(dir, "log")
+
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "build" {
+  inputs = ["` + in + `"]
+  outputs = ["` + out + `"]
+
+  exec {
+    command = "bash"
+    args = ["-c", "cat ` + in + ` > ` + out + `; echo built >> ` + log + `; cat ` + out + `"]
+  }
+
+  output "content" {
+    type = string
+    value = trimspace(res.stdout)
+  }
+}
+
+job "release" {
+  step "build" {
+    run "build" {}
+  }
+
+  step "show" {
+    run "show" {
+      content = step.build.outputs.content
+    }
+    need = ["build"]
+  }
+}
+
+job "show" {
+  option "content" {
+    type = string
+  }
+
+  exec {
+    command = "echo"
+    args = [opt.content]
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Stdout = os.Stdout
+	app.Stderr = os.Stderr
+	app.CacheDir = filepath.Join(dir, "cache")
+
+	write := func(content string, mtime time.Time) {
+		t.Helper()
+
+		if err := ioutil.WriteFile(in, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(in, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(wantSkipped bool, wantBuilds int, wantContent string) {
+		t.Helper()
+
+		res, err := app.Run("build", map[string]interface{}{}, map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if res.Skipped != wantSkipped {
+			t.Errorf("unexpected skipped: want %v, got %v", wantSkipped, res.Skipped)
+		}
+
+		// Outputs of the skipped job are the ones of the last successful run
+		if got := res.Outputs["content"]; !got.RawEquals(cty.StringVal(wantContent)) {
+			t.Errorf("unexpected output: want %q, got %#v", wantContent, got)
+		}
+
+		got, err := ioutil.ReadFile(log)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n := strings.Count(string(got), "built"); n != wantBuilds {
+			t.Errorf("unexpected number of builds: want %d, got %d", wantBuilds, n)
+		}
+	}
+
+	write("v1", time.Now().Add(-time.Hour))
+	run(false, 1, "v1")
+
+	// The output is newer than the input
+	run(true, 1, "v1")
+
+	// The input is newer than the output, but its content is unchanged
+	write("v1", time.Now().Add(time.Hour))
+	run(true, 1, "v1")
+
+	write("v2", time.Now().Add(time.Hour))
+	run(false, 2, "v2")
+
+	// The later step can refer to the outputs of the skipped job
+	res, err := app.Run("release", map[string]interface{}{}, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := strings.TrimSpace(res.Stdout); got != "v2" {
+		t.Errorf("unexpected stdout: want %q, got %q", "v2", got)
+	}
+}
+
+func TestScriptErrorLine(t *testing.T) {
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "fail" {
+  exec {
+    script = <<EOS
+echo ok
+nosuchcommand
+EOS
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stderr := &bytes.Buffer{}
+
+	app.Stdout = ioutil.Discard
+	app.Stderr = stderr
+
+	_, err = app.Run("fail", map[string]interface{}{}, map[string]interface{}{})
+	if err == nil {
+		t.Fatal("expected error, got none")
+	}
+
+	if want := "script at main.variant:4,"; !strings.Contains(err.Error(), want) {
+		t.Errorf("unexpected error: want %q to be contained in %q", want, err.Error())
+	}
+
+	if want := "main.variant:6: nosuchcommand: command not found"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("unexpected stderr: want %q to be contained in %q", want, stderr.String())
+	}
+}
+
+func TestSecretMasking(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "log")
+
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "deploy" {
+  option "token" {
+    type = string
+    sensitive = true
+  }
+
+  log {
+    file = "` + logFile + `"
+    collect {
+      condition = event.type == "exec"
+      format = "exec ${event.exec.command} ${join(" ", event.exec.args)}"
+    }
+  }
+
+  step "login" {
+    run "login" {
+      password = opt.token
+    }
+  }
+}
+
+job "login" {
+  option "password" {
+    type = string
+  }
+
+  exec {
+    command = "sh"
+    args = ["-c", "echo password=${opt.password}; echo error=${opt.password} 1>&2; exit 1", opt.password]
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	app.Stdout = stdout
+	app.Stderr = stderr
+	app.Trace = "true"
+
+	_, err = app.Run("deploy", map[string]interface{}{}, map[string]interface{}{"token": "s3cr3t"})
+	if err == nil {
+		t.Fatal("expected error, got none")
+	}
+
+	log, readErr := ioutil.ReadFile(logFile)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	for name, out := range map[string]string{
+		"stdout": stdout.String(),
+		"stderr": stderr.String(),
+		"error":  err.Error(),
+		"log":    string(log),
+	} {
+		if strings.Contains(out, "s3cr3t") {
+			t.Errorf("%s contains the secret: %s", name, out)
+		}
+
+		if !strings.Contains(out, SecretMask) {
+			t.Errorf("%s does not contain the masked secret: %s", name, out)
+		}
+	}
+}
+
+func TestOutputMode(t *testing.T) {
+	source := []byte(`
+job "hello" {
+  option "n" {
+    type = string
+  }
+
+  exec {
+    command = "sh"
+    args = ["-c", "for i in 1 2; do echo ${opt.n}$i; sleep 0.1; done"]
+  }
+}
+
+job "all" {
+  concurrency = 2
+
+  step "a" {
+    run "hello" {
+      n = "a"
+    }
+  }
+
+  step "b" {
+    run "hello" {
+      n = "b"
+    }
+  }
+}
+`)
+
+	testcases := []struct {
+		mode string
+		want []string
+	}{
+		{
+			mode: OutputModePrefixed,
+			want: []string{"[a] a1\n[a] a2\n", "[b] b1\n[b] b2\n"},
+		},
+		{
+			mode: OutputModeGrouped,
+			want: []string{"==> a\na1\na2\n", "==> b\nb1\nb2\n"},
+		},
+	}
+
+	for i := range testcases {
+		tc := testcases[i]
+
+		t.Run(tc.mode, func(t *testing.T) {
+			app, err := New(FromSources(map[string][]byte{"main.variant": source}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stdout := &bytes.Buffer{}
+
+			app.Stdout = stdout
+			app.Stderr = ioutil.Discard
+			app.OutputMode = tc.mode
+
+			if _, err := app.Run("all", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+				t.Fatal(err)
+			}
+
+			got := stdout.String()
+
+			if tc.mode == OutputModePrefixed {
+				// Lines from the concurrent steps interleave, but are attributed to the steps
+				var a, b []string
+
+				for _, l := range strings.SplitAfter(got, "\n") {
+					switch {
+					case strings.HasPrefix(l, "[a] "):
+						a = append(a, l)
+					case strings.HasPrefix(l, "[b] "):
+						b = append(b, l)
+					case l != "":
+						t.Errorf("unexpected line without the step name: %q", l)
+					}
+				}
+
+				got = strings.Join(a, "") + strings.Join(b, "")
+			}
+
+			if got != strings.Join(tc.want, "") && got != tc.want[1]+tc.want[0] {
+				t.Errorf("unexpected stdout: want %q, got %q", strings.Join(tc.want, ""), got)
+			}
+		})
+	}
+}
+
+func TestJobOutputs(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "build" {
+  cache {
+    key = ["build"]
+  }
+
+  exec {
+    command = "bash"
+    args = ["-c", "echo '{\"image\": \"app\", \"layers\": [3, 5]}' | tee -a ` + log + `"]
+  }
+
+  output "image" {
+    type = string
+    value = json.image
+  }
+
+  output "layers" {
+    type = list(number)
+    value = json.layers
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Stdout = ioutil.Discard
+	app.Stderr = os.Stderr
+	app.CacheDir = filepath.Join(dir, "cache")
+
+	// The second run restores the outputs from the cached result
+	for i := 0; i < 2; i++ {
+		res, err := app.Run("build", map[string]interface{}{}, map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("run %d: unexpected error: %v", i, err)
+		}
+
+		if got := res.Outputs["image"]; !got.RawEquals(cty.StringVal("app")) {
+			t.Errorf("run %d: unexpected image: %#v", i, got)
+		}
+
+		if got, want := res.Outputs["layers"], cty.ListVal([]cty.Value{cty.NumberIntVal(3), cty.NumberIntVal(5)}); !got.Equals(want).True() || !got.Type().Equals(want.Type()) {
+			t.Errorf("run %d: unexpected layers: %#v", i, got)
+		}
+	}
+
+	if got, _ := ioutil.ReadFile(log); strings.Count(string(got), "\n") != 1 {
+		t.Errorf("job must be run only once: %q", string(got))
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+
+	source := []byte(`
+job "apply" {
+  option "env" {
+    type = string
+  }
+
+  lock = "terraform-${opt.env}"
+  lock_timeout = "300ms"
+
+  step "plan" {
+    run "terraform" {
+      env = opt.env
+    }
+  }
+}
+
+job "terraform" {
+  option "env" {
+    type = string
+  }
+
+  exec {
+    command = "bash"
+    args = ["-c", "echo start ${opt.env} >> ` + log + `; sleep 0.2; echo end ${opt.env} >> ` + log + `"]
+  }
+}
+
+job "all" {
+  concurrency = 2
+
+  step "a" {
+    run "terraform" {
+      env = "prod"
+    }
+    lock = "terraform-prod"
+  }
+
+  step "b" {
+    run "apply" {
+      env = "prod"
+    }
+  }
+}
+
+job "serial" {
+  concurrency = 2
+  lock = "terraform-prod"
+
+  step "a" {
+    run "terraform" {
+      env = "prod"
+    }
+    lock = "terraform-prod"
+  }
+
+  step "b" {
+    run "terraform" {
+      env = "prod"
+    }
+    lock = "terraform-prod"
+  }
+}
+`)
+
+	newApp := func() *App {
+		t.Helper()
+
+		app, err := New(FromSources(map[string][]byte{"main.variant": source}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		app.Stdout = ioutil.Discard
+		app.Stderr = ioutil.Discard
+		app.CacheDir = filepath.Join(dir, "cache")
+
+		return app
+	}
+
+	app := newApp()
+
+	// Step "b" waits for step "a", and then acquires the same lock again in the job "apply" it calls
+	if _, err := app.Run("all", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := ioutil.ReadFile(log); string(got) != "start prod\nend prod\nstart prod\nend prod\n" {
+		t.Errorf("steps sharing the lock must not run concurrently: %q", string(got))
+	}
+
+	if err := os.Remove(log); err != nil {
+		t.Fatal(err)
+	}
+
+	// The steps sharing the lock held by their job still run one at a time
+	if _, err := app.Run("serial", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := ioutil.ReadFile(log); string(got) != "start prod\nend prod\nstart prod\nend prod\n" {
+		t.Errorf("steps sharing the lock of their job must not run concurrently: %q", string(got))
+	}
+
+	// Another process holding the lock is emulated by another app sharing the lock directory
+	release, err := newApp().acquireLock(context.Background(), &JobContext{}, "terraform-prod", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = app.Run("apply", map[string]interface{}{}, map[string]interface{}{"env": "prod"})
+	if err == nil || !strings.Contains(err.Error(), `timed out after 300ms waiting for lock "terraform-prod"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := app.Run("apply", map[string]interface{}{}, map[string]interface{}{"env": "dev"}); err != nil {
+		t.Errorf("unexpected error for another lock: %v", err)
+	}
+
+	release()
+
+	if _, err := app.Run("apply", map[string]interface{}{}, map[string]interface{}{"env": "prod"}); err != nil {
+		t.Errorf("unexpected error after the lock is released: %v", err)
+	}
+}
+
+func TestApproval(t *testing.T) {
+	app, err := New(FromSources(map[string][]byte{
+		"main.variant": []byte(`
+job "deploy" {
+  option "env" {
+    type = string
+  }
+
+  approval {
+    message = "Deploy to ${opt.env}?"
+    required = opt.env == "prd"
+  }
+
+  exec {
+    command = "echo"
+    args = ["deploying to ${opt.env}"]
+  }
+}
+
+job "release" {
+  step "deploy" {
+    run "deploy" {
+      env = "prd"
+    }
+  }
+}
+
+job "rollout" {
+  approval {}
+
+  step "build" {
+    run "deploy" {
+      env = "dev"
+    }
+  }
+
+  step "deploy" {
+    run "deploy" {
+      env = "prd"
+    }
+    need = ["build"]
+  }
+}
+`),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.Stdout = ioutil.Discard
+	app.Stderr = ioutil.Discard
+
+	var asked []string
+
+	answer := func(approved bool) SetOptsFunc {
+		return func(opts map[string]cty.Value, pendingOptions []PendingInput) error {
+			for _, in := range pendingOptions {
+				if !in.Approval || in.Name != ApprovalInputName {
+					t.Errorf("unexpected input: %v", in)
+
+					continue
+				}
+
+				asked = append(asked, *in.Description)
+
+				opts[in.Name] = cty.BoolVal(approved)
+			}
+
+			return nil
+		}
+	}
+
+	run := func(job, env string, fs ...SetOptsFunc) (*Result, error) {
+		opts := map[string]interface{}{}
+
+		if env != "" {
+			opts["env"] = env
+		}
+
+		return app.Run(job, map[string]interface{}{}, opts, fs...)
+	}
+
+	if _, err := run("deploy", "dev"); err != nil {
+		t.Errorf("approval must not be required for dev: %v", err)
+	}
+
+	if _, err := run("deploy", "prd"); err == nil || !strings.Contains(err.Error(), `job "deploy" requires approval: Deploy to prd?`) {
+		t.Errorf("unexpected error without approver: %v", err)
+	}
+
+	if _, err := run("deploy", "prd", answer(false)); err == nil || !strings.Contains(err.Error(), `job "deploy" was not approved`) {
+		t.Errorf("unexpected error on denial: %v", err)
+	}
+
+	res, err := run("deploy", "prd", answer(true))
+	if err != nil {
+		t.Fatalf("unexpected error on approval: %v", err)
+	}
+
+	if got := strings.TrimSpace(res.Stdout); got != "deploying to prd" {
+		t.Errorf("unexpected stdout: %q", got)
+	}
+
+	want := "Deploy to prd?\n\njob \"deploy\"\n  exec: \"echo\" \"deploying to prd\""
+
+	if len(asked) != 2 || asked[1] != want {
+		t.Errorf("unexpected approval requests: want the plan %q, got %q", want, asked)
+	}
+
+	// The approver given to the command is asked for the approval of the jobs it calls
+	if _, err := run("release", "", answer(true)); err != nil {
+		t.Errorf("unexpected error on approval of the callee: %v", err)
+	}
+
+	if len(asked) != 3 {
+		t.Errorf("approval of the callee must be asked: %q", asked)
+	}
+
+	// Nothing is run to build the plan of the job with steps
+	recorder := &concurrencyRecorder{}
+
+	app.Executor = recorder
+
+	if _, err := run("rollout", "", answer(false)); err == nil {
+		t.Error("expected error did not occur")
+	}
+
+	app.Executor = nil
+
+	if recorder.max != 0 {
+		t.Error("no command must be run before the approval")
+	}
+
+	want = "Run job \"rollout\"?\n\njob \"rollout\"\n  wave 1: build\n  wave 2: deploy\n  step \"build\": run \"deploy\"\n  step \"deploy\": run \"deploy\""
+
+	if len(asked) != 4 || asked[3] != want {
+		t.Errorf("unexpected approval requests: want the plan %q, got %q", want, asked)
+	}
+
+	app.AssumeYes = true
+
+	if _, err := run("deploy", "prd"); err != nil {
+		t.Errorf("unexpected error with AssumeYes: %v", err)
+	}
+}
+
+// concurrencyRecorder is the Executor that records the maximum number of commands run at the same time.
+type concurrencyRecorder struct {
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func (e *concurrencyRecorder) Run(_ context.Context, _ Command) (*Result, error) {
+	e.mu.Lock()
+	e.running++
+	if e.running > e.max {
+		e.max = e.running
+	}
+	e.mu.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+
+	e.mu.Lock()
+	e.running--
+	e.mu.Unlock()
+
+	return &Result{}, nil
+}
+
+func TestMaxParallel(t *testing.T) {
+	jobs := `
+job "group" {
+  option "name" {
+    type = string
+  }
+
+  concurrency = 4
+
+  step "a" {
+    run "hello" {
+      name = "${opt.name}a"
+    }
+  }
+
+  step "b" {
+    run "hello" {
+      name = "${opt.name}b"
+    }
+  }
+
+  step "c" {
+    run "hello" {
+      name = "${opt.name}c"
+    }
+  }
+}
+
+job "hello" {
+  option "name" {
+    type = string
+  }
+
+  exec {
+    command = "echo"
+    args = [opt.name]
+  }
+}
+
+job "all" {
+  concurrency = 3
+
+  step "x" {
+    run "group" {
+      name = "x"
+    }
+  }
+
+  step "y" {
+    run "group" {
+      name = "y"
+    }
+  }
+
+  depends_on "group" {
+    items = [{name = "z"}, {name = "w"}]
+    args = {
+      name = item.name
+    }
+  }
+}
+`
+
+	testcases := []struct {
+		subject     string
+		attr        string
+		maxParallel int
+		want        int
+	}{
+		{
+			subject: "no limit",
+			want:    6,
+		},
+		{
+			subject:     "App.MaxParallel",
+			maxParallel: 2,
+			want:        2,
+		},
+		{
+			subject: "max_parallel",
+			attr:    "max_parallel = 3\n",
+			want:    3,
+		},
+		{
+			subject:     "App.MaxParallel overrides max_parallel",
+			attr:        "max_parallel = 3\n",
+			maxParallel: 1,
+			want:        1,
+		},
+	}
+
+	for i := range testcases {
+		tc := testcases[i]
+
+		t.Run(tc.subject, func(t *testing.T) {
+			app, err := New(FromSources(map[string][]byte{"main.variant": []byte(tc.attr + jobs)}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e := &concurrencyRecorder{}
+
+			app.Stdout = ioutil.Discard
+			app.Stderr = ioutil.Discard
+			app.Executor = e
+			app.MaxParallel = tc.maxParallel
+
+			if _, err := app.Run("all", map[string]interface{}{}, map[string]interface{}{}); err != nil {
+				t.Fatal(err)
+			}
+
+			if e.max != tc.want {
+				t.Errorf("unexpected max number of commands run at the same time: want %d, got %d", tc.want, e.max)
+			}
+		})
+	}
+}
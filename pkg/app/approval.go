@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+	gohcl2 "github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/variantdev/dag/pkg/dag"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+)
+
+// ApprovalInputName is the name of the PendingInput given to SetOptsFunc to ask for the approval of a job.
+// SetOptsFunc approves the job by setting it to true.
+const ApprovalInputName = "approve"
+
+// approve asks for the approval of the job that has the `approval` block, via the SetOptsFunc of the run.
+// It fails when the approval is required but there is no one to ask, unless App.AssumeYes is set.
+func (app *App) approve(jobCtx *JobContext, j JobSpec, f SetOptsFunc) error {
+	if j.Approval == nil {
+		return nil
+	}
+
+	evalCtx := jobCtx.evalContext
+
+	required := true
+
+	if j.Approval.Required != nil && !IsExpressionEmpty(j.Approval.Required) {
+		if diags := gohcl2.DecodeExpression(j.Approval.Required, evalCtx, &required); diags.HasErrors() {
+			return xerrors.Errorf("approval: required: %w", diags)
+		}
+	}
+
+	if !required {
+		return nil
+	}
+
+	message := fmt.Sprintf("Run job %q?", j.Name)
+
+	if j.Approval.Message != nil && !IsExpressionEmpty(j.Approval.Message) {
+		if diags := gohcl2.DecodeExpression(j.Approval.Message, evalCtx, &message); diags.HasErrors() {
+			return xerrors.Errorf("approval: message: %w", diags)
+		}
+	}
+
+	if app.AssumeYes {
+		return nil
+	}
+
+	if f == nil {
+		return fmt.Errorf("job %q requires approval: %s\nRun it interactively, or with --yes to approve it", j.Name, message)
+	}
+
+	description := message
+
+	if plan := app.approvalPlan(jobCtx, j); plan != "" {
+		description += "\n\n" + plan
+	}
+
+	answers := map[string]cty.Value{}
+
+	if err := f(answers, []PendingInput{{Name: ApprovalInputName, Description: &description, Type: cty.Bool, Approval: true}}); err != nil {
+		return xerrors.Errorf("asking for approval of job %q: %w", j.Name, err)
+	}
+
+	if v, ok := answers[ApprovalInputName]; !ok || v.IsNull() || v.Type() != cty.Bool || v.False() {
+		return fmt.Errorf("job %q was not approved", j.Name)
+	}
+
+	return nil
+}
+
+// approvalPlan returns the steps and the command of the job, built from the spec of the job and the args already
+// evaluated for it. Nothing is run to build the plan, so callees of the steps are listed only by their names.
+func (app *App) approvalPlan(jobCtx *JobContext, j JobSpec) string {
+	var buf bytes.Buffer
+
+	app.printDryRunJob(&buf, j.Name)
+
+	if len(j.Steps) > 0 {
+		waves, err := stepWaves(j.Steps)
+		if err != nil {
+			fmt.Fprintf(&buf, "  (the steps are unknown: %v)\n", err)
+		} else {
+			app.printDryRunWaves(&buf, waves)
+		}
+
+		for _, s := range j.Steps {
+			fmt.Fprintf(&buf, "  step %q: run %q\n", s.Name, s.Run.Name)
+		}
+	}
+
+	// Only the names of the jobs run by `run` blocks are listed, as their args are evaluated when they are run
+	if j.Body != nil {
+		content, _, diags := j.Body.PartialContent(&hcl2.BodySchema{
+			Blocks: []hcl2.BlockHeaderSchema{{Type: "run", LabelNames: []string{"name"}}},
+		})
+		if !diags.HasErrors() {
+			for _, b := range content.Blocks {
+				fmt.Fprintf(&buf, "  run %q\n", b.Labels[0])
+			}
+		}
+	}
+
+	if j.Exec != nil {
+		evalCtx := jobCtx.evalContext
+
+		if j.Exec.Retry != nil {
+			evalCtx = cloneEvalContext(evalCtx)
+			evalCtx.Variables["retry"] = retryVal(1, nil, nil)
+		}
+
+		c, err := decodeExec(j.Exec, evalCtx)
+		if err != nil {
+			fmt.Fprintf(&buf, "  (the command is unknown: %v)\n", err)
+		} else {
+			c.Env = mergeEnv(jobCtx.env, c.Env)
+
+			app.printDryRunExec(&buf, *c)
+		}
+	}
+
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// stepWaves groups the steps by waves of concurrently runnable steps, in the order they would be run.
+// Unlike the actual run, for_each and matrix steps are not expanded.
+func stepWaves(steps []Step) ([][]string, error) {
+	names := make([]string, 0, len(steps))
+	index := map[string]int{}
+
+	for i, s := range steps {
+		names = append(names, s.Name)
+		index[s.Name] = i
+	}
+
+	g := dag.New(dag.Nodes(names))
+
+	for _, s := range steps {
+		if s.Needs != nil {
+			g.AddDependencies(s.Name, *s.Needs)
+		}
+	}
+
+	plan, err := g.Plan()
+	if err != nil {
+		return nil, err
+	}
+
+	var waves [][]string
+
+	for _, nodes := range plan {
+		ids := []string{}
+		for _, n := range nodes {
+			ids = append(ids, n.Id)
+		}
+
+		// Preserve the order of definitions
+		sort.Slice(ids, func(i, j int) bool {
+			return index[ids[i]] < index[ids[j]]
+		})
+
+		waves = append(waves, ids)
+	}
+
+	return waves, nil
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -62,7 +63,7 @@ func loadConfigSourceContent(sourceSpec ConfigSource) (*hcl.BodyContent, error)
 	return content, nil
 }
 
-func (app *App) loadConfigSource(jobCtx *JobContext, confCtx *hcl.EvalContext, sourceSpec ConfigSource) ([]configFragment, error) {
+func (app *App) loadConfigSource(ctx context.Context, jobCtx *JobContext, confCtx *hcl.EvalContext, sourceSpec ConfigSource) ([]configFragment, error) {
 	var err error
 
 	var fragments []configFragment
@@ -74,7 +75,7 @@ func (app *App) loadConfigSource(jobCtx *JobContext, confCtx *hcl.EvalContext, s
 			return nil, err
 		}
 	case "job":
-		fragments, err = app.loadJobConfigSource(jobCtx, confCtx, sourceSpec)
+		fragments, err = app.loadJobConfigSource(ctx, jobCtx, confCtx, sourceSpec)
 		if err != nil {
 			return nil, err
 		}
@@ -85,7 +86,7 @@ func (app *App) loadConfigSource(jobCtx *JobContext, confCtx *hcl.EvalContext, s
 	return fragments, nil
 }
 
-func (app *App) loadJobConfigSource(jobCtx *JobContext, confCtx *hcl.EvalContext, sourceSpec ConfigSource) ([]configFragment, error) {
+func (app *App) loadJobConfigSource(ctx context.Context, jobCtx *JobContext, confCtx *hcl.EvalContext, sourceSpec ConfigSource) ([]configFragment, error) {
 	var source SourceJob
 	if err := gohcl2.DecodeBody(sourceSpec.Body, confCtx, &source); err != nil {
 		return nil, xerrors.Errorf("decoding job body: %w", err)
@@ -96,7 +97,7 @@ func (app *App) loadJobConfigSource(jobCtx *JobContext, confCtx *hcl.EvalContext
 		return nil, err
 	}
 
-	res, err := app.run(jobCtx, nil, source.Name, args, false)
+	res, err := app.run(ctx, jobCtx, nil, source.Name, args, false)
 	if err != nil {
 		return nil, err
 	}
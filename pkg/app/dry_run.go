@@ -0,0 +1,57 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// printDryRunJob prints the job that would be run in the dry-run mode.
+func (app *App) printDryRunJob(w io.Writer, name string) {
+	fmt.Fprintf(w, "job %q\n", name)
+}
+
+// printDryRunWaves prints the steps grouped by waves of concurrently runnable steps, in the order they would be run.
+func (app *App) printDryRunWaves(w io.Writer, waves [][]string) {
+	for i, ids := range waves {
+		fmt.Fprintf(w, "  wave %d: %s\n", i+1, strings.Join(ids, ", "))
+	}
+}
+
+// printDryRunExec prints the fully interpolated command that would be executed.
+func (app *App) printDryRunExec(w io.Writer, cmd Command) {
+	quoted := []string{fmt.Sprintf("%q", cmd.Name)}
+
+	for _, a := range cmd.Args {
+		quoted = append(quoted, fmt.Sprintf("%q", a))
+	}
+
+	lines := []string{fmt.Sprintf("  exec: %s", strings.Join(quoted, " "))}
+
+	if cmd.Dir != "" {
+		lines = append(lines, fmt.Sprintf("    dir: %s", cmd.Dir))
+	}
+
+	var names []string
+
+	for n := range cmd.Env {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	for _, n := range names {
+		lines = append(lines, fmt.Sprintf("    env: %s=%s", n, cmd.Env[n]))
+	}
+
+	if cmd.Script != "" {
+		lines = append(lines, "    script:")
+
+		for _, l := range strings.Split(strings.TrimRight(cmd.Script, "\n"), "\n") {
+			lines = append(lines, "      "+l)
+		}
+	}
+
+	fmt.Fprintln(w, app.sanitize(strings.Join(lines, "\n")))
+}
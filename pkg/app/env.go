@@ -0,0 +1,76 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+	gohcl2 "github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// decodeEnvInherit decodes `env_inherit` that is either a bool or a list of names of envvars.
+// It returns nil when all the envvars are inherited.
+func decodeEnvInherit(expr hcl2.Expression, evalCtx *hcl2.EvalContext) ([]string, error) {
+	v, diags := expr.Value(evalCtx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	if v.Type() == cty.Bool {
+		if v.True() {
+			return nil, nil
+		}
+
+		return []string{}, nil
+	}
+
+	names := []string{}
+
+	if diags := gohcl2.DecodeExpression(expr, evalCtx, &names); diags.HasErrors() {
+		return nil, fmt.Errorf("it must be either a bool or a list of names of environment variables: %w", diags)
+	}
+
+	return names, nil
+}
+
+// inheritedEnv returns the os envvars inherited by the command. nil names means that all of them are inherited.
+func inheritedEnv(names []string) map[string]string {
+	env := map[string]string{}
+
+	for _, e := range os.Environ() {
+		pair := strings.SplitN(e, "=", 2)
+
+		env[pair[0]] = pair[1]
+	}
+
+	if names == nil {
+		return env
+	}
+
+	allowed := map[string]string{}
+
+	for _, n := range names {
+		if v, ok := env[n]; ok {
+			allowed[n] = v
+		}
+	}
+
+	return allowed
+}
+
+// mergeEnv returns the envvars in base overridden by the ones in overrides.
+func mergeEnv(base, overrides map[string]string) map[string]string {
+	env := map[string]string{}
+
+	for k, v := range base {
+		env[k] = v
+	}
+
+	for k, v := range overrides {
+		env[k] = v
+	}
+
+	return env
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/variantdev/mod/pkg/shell"
+)
+
+// terminationGracePeriod is how long the process tree of a command is given to exit after SIGTERM, before it gets SIGKILL.
+var terminationGracePeriod = 10 * time.Second
+
+// contextExec returns a shell.Exec that runs the command in its own process group,
+// so that the whole process tree started by the command can be stopped once ctx is done.
+func contextExec(ctx context.Context) shell.Exec {
+	return func(c *shell.Command) shell.Result {
+		cmd := exec.Command(c.Name, c.Args...)
+
+		// Never leave it nil, which would make the command inherit all the envvars of variant
+		env := []string{}
+		for n, v := range c.Env {
+			env = append(env, fmt.Sprintf("%s=%s", n, v))
+		}
+
+		cmd.Env = env
+
+		if c.Dir != "" {
+			cmd.Dir = c.Dir
+		}
+
+		if c.Stdin != nil {
+			cmd.Stdin = c.Stdin
+		}
+
+		if c.Stdout != nil {
+			cmd.Stdout = c.Stdout
+		}
+
+		if c.Stderr != nil {
+			cmd.Stderr = c.Stderr
+		}
+
+		setProcessGroup(cmd)
+
+		if err := ctx.Err(); err != nil {
+			return shell.Result{ExitStatus: 1, Error: err}
+		}
+
+		if err := cmd.Start(); err != nil {
+			return shell.Result{ExitStatus: 1, Error: err}
+		}
+
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-done:
+				return
+			}
+
+			terminateProcessGroup(cmd)
+
+			select {
+			case <-done:
+			case <-time.After(terminationGracePeriod):
+			}
+
+			// Kill whatever is left in the process tree, including grandchildren that ignored SIGTERM
+			killProcessGroup(cmd)
+		}()
+
+		if err := cmd.Wait(); err != nil {
+			//nolint:errorlint
+			if exitError, ok := err.(*exec.ExitError); ok {
+				waitStatus := exitError.Sys().(syscall.WaitStatus)
+
+				return shell.Result{ExitStatus: waitStatus.ExitStatus(), Error: exitError}
+			}
+
+			return shell.Result{ExitStatus: 1, Error: err}
+		}
+
+		waitStatus := cmd.ProcessState.Sys().(syscall.WaitStatus)
+
+		return shell.Result{ExitStatus: waitStatus.ExitStatus(), Error: nil}
+	}
+}
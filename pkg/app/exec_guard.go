@@ -0,0 +1,174 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+	gohcl2 "github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+)
+
+// execSkipReason evaluates the `creates`, `removes` and `unless` guards of the exec,
+// and returns the reason to skip the exec, or an empty string when the exec should run.
+func (app *App) execSkipReason(ctx context.Context, l *EventLogger, jobCtx *JobContext, e *Exec, cmd *Command, evalCtx *hcl2.EvalContext) (string, error) {
+	if e.Creates != nil && !IsExpressionEmpty(e.Creates) {
+		path, exists, err := guardPathExists(e.Creates, evalCtx, cmd.Dir)
+		if err != nil {
+			return "", xerrors.Errorf("creates: %w", err)
+		}
+
+		if exists {
+			return fmt.Sprintf("%s exists", path), nil
+		}
+	}
+
+	if e.Removes != nil && !IsExpressionEmpty(e.Removes) {
+		path, exists, err := guardPathExists(e.Removes, evalCtx, cmd.Dir)
+		if err != nil {
+			return "", xerrors.Errorf("removes: %w", err)
+		}
+
+		if !exists {
+			return fmt.Sprintf("%s does not exist", path), nil
+		}
+	}
+
+	if e.Unless != nil && !IsExpressionEmpty(e.Unless) {
+		succeeded, err := app.execUnless(ctx, l, jobCtx, e.Unless, cmd, evalCtx)
+		if err != nil {
+			return "", xerrors.Errorf("unless: %w", err)
+		}
+
+		if succeeded {
+			return "unless check succeeded", nil
+		}
+	}
+
+	return "", nil
+}
+
+// guardPathExists tells if any file matches the path pattern, which is relative to the dir of the exec.
+func guardPathExists(expr hcl2.Expression, evalCtx *hcl2.EvalContext, dir string) (string, bool, error) {
+	var path string
+
+	if diags := gohcl2.DecodeExpression(expr, evalCtx, &path); diags.HasErrors() {
+		return "", false, diags
+	}
+
+	pattern := path
+
+	if dir != "" && !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", false, err
+	}
+
+	return path, len(matches) > 0, nil
+}
+
+// execUnless runs the `unless` check and tells if it succeeded.
+// The check is either a shell script, a list of the command and its args, or an object like `{ job = "name", args = {} }`.
+func (app *App) execUnless(ctx context.Context, l *EventLogger, jobCtx *JobContext, expr hcl2.Expression, cmd *Command, evalCtx *hcl2.EvalContext) (bool, error) {
+	v, diags := expr.Value(evalCtx)
+	if diags.HasErrors() {
+		return false, diags
+	}
+
+	var err error
+
+	tpe := v.Type()
+
+	switch {
+	case tpe == cty.String:
+		_, err = app.execCmd(ctx, jobCtx, Command{
+			Name: "sh",
+			Args: []string{"-c", v.AsString()},
+			Env:  cmd.Env,
+			Dir:  cmd.Dir,
+
+			InheritEnv: cmd.InheritEnv,
+		}, false)
+	case tpe.IsListType() || tpe.IsTupleType():
+		var argv []string
+
+		if diags := gohcl2.DecodeExpression(expr, evalCtx, &argv); diags.HasErrors() {
+			return false, diags
+		}
+
+		if len(argv) == 0 {
+			return false, fmt.Errorf("the command must not be empty")
+		}
+
+		_, err = app.execCmd(ctx, jobCtx, Command{
+			Name: argv[0],
+			Args: argv[1:],
+			Env:  cmd.Env,
+			Dir:  cmd.Dir,
+
+			InheritEnv: cmd.InheritEnv,
+		}, false)
+	case tpe.IsObjectType() && tpe.HasAttribute("job"):
+		run, rErr := unlessJobRun(jobCtx, v)
+		if rErr != nil {
+			return false, rErr
+		}
+
+		_, err = app.run(ctx, jobCtx, l, run.Name, run.Args, false)
+	default:
+		return false, fmt.Errorf("unsupported value of type %s: it must be either a string, a list of strings, or an object with the `job` attribute", tpe.FriendlyName())
+	}
+
+	if err != nil {
+		// The check is not considered failed when the whole run is being cancelled
+		if ctx.Err() != nil {
+			return false, err
+		}
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func unlessJobRun(jobCtx *JobContext, v cty.Value) (*jobRun, error) {
+	job := v.GetAttr("job")
+	if job.Type() != cty.String || job.IsNull() {
+		return nil, fmt.Errorf("job must be a string")
+	}
+
+	args := map[string]interface{}{}
+
+	for k, v := range jobCtx.globalArgs {
+		args[k] = v
+	}
+
+	if v.Type().HasAttribute("args") {
+		a := v.GetAttr("args")
+
+		if !a.IsNull() {
+			if !a.Type().IsObjectType() && !a.Type().IsMapType() {
+				return nil, fmt.Errorf("args must be an object")
+			}
+
+			for k, av := range a.AsValueMap() {
+				goV, err := ctyToGo(av)
+				if err != nil {
+					return nil, xerrors.Errorf("args.%s: %w", k, err)
+				}
+
+				args[k] = goV
+			}
+		}
+	}
+
+	return &jobRun{
+		Name: job.AsString(),
+		Args: args,
+	}, nil
+}
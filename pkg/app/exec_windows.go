@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package app
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(_ *exec.Cmd) {}
+
+func terminateProcessGroup(cmd *exec.Cmd) {
+	killProcessGroup(cmd)
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+
+	_ = cmd.Process.Kill()
+}
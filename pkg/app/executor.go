@@ -0,0 +1,169 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/variantdev/mod/pkg/shell"
+	"golang.org/x/xerrors"
+)
+
+// Executor runs the command of the `exec` block.
+//
+// It can be set to App.Executor to route every command to e.g. a remote runner, a sandbox or a recorder,
+// or registered to App.Executors so that it is selected per job by the `executor` attribute.
+//
+// On failure, Run should return the result along with the error, so that e.g. the exit status is available.
+type Executor interface {
+	Run(ctx context.Context, cmd Command) (*Result, error)
+}
+
+// localExecutor is the default Executor that runs the command in a local process.
+type localExecutor struct {
+	app *App
+}
+
+func (e *localExecutor) Run(ctx context.Context, cmd Command) (*Result, error) {
+	args := cmd.Args
+
+	rewrite := func(s string) string { return s }
+
+	if cmd.Script != "" {
+		path, remove, err := writeScript(cmd.Script)
+		if err != nil {
+			return nil, xerrors.Errorf("writing script: %w", err)
+		}
+
+		defer remove()
+
+		args = cmd.withScriptPath(path)
+		rewrite = e.app.scriptLineRewriter(path, cmd.ScriptRange)
+	}
+
+	// We need to explicitly inherit os envvars.
+	// Otherwise the command is executed in an env that misses all of them, including the important one like PATH,
+	// which is confusing to users.
+	// `env_inherit` turns off the inheritance, or limits it to the allowed envvars.
+	env := mergeEnv(inheritedEnv(cmd.InheritEnv), cmd.Env)
+
+	outputFile, removeOutputFile, err := createOutputFile()
+	if err != nil {
+		return nil, xerrors.Errorf("creating output file: %w", err)
+	}
+
+	defer removeOutputFile()
+
+	env[OutputFileEnv] = outputFile
+
+	shellCmd := &shell.Command{
+		Name:  cmd.Name,
+		Args:  args,
+		Env:   env,
+		Dir:   cmd.Dir,
+		Stdin: cmd.Stdin,
+	}
+
+	if cmd.StdinFile != "" {
+		path := cmd.StdinFile
+
+		if cmd.Dir != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(cmd.Dir, path)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, xerrors.Errorf("opening stdin_file: %w", err)
+		}
+
+		defer f.Close()
+
+		shellCmd.Stdin = f
+	}
+
+	sh := shell.Shell{
+		Exec: contextExec(ctx),
+	}
+
+	var re *Result
+
+	if cmd.Interactive {
+		if shellCmd.Stdin == nil {
+			shellCmd.Stdin = os.Stdin
+		}
+
+		// The terminal is directly connected to the command, so secrets are not masked in its output
+		shellCmd.Stdout = os.Stdout
+		shellCmd.Stderr = os.Stderr
+
+		res := sh.Wait(shellCmd)
+
+		err = res.Error
+
+		re = &Result{}
+	} else {
+		var opts shell.CaptureOpts
+
+		if cmd.Stdout != nil {
+			opts.LogStdout = func(line string) {
+				fmt.Fprintf(cmd.Stdout, "%s\n", line)
+			}
+		}
+
+		if cmd.Stderr != nil {
+			opts.LogStderr = func(line string) {
+				fmt.Fprintf(cmd.Stderr, "%s\n", rewrite(line))
+			}
+		}
+
+		var res *shell.CaptureResult
+
+		res, err = sh.Capture(shellCmd, opts)
+
+		re = &Result{
+			Stdout: res.Stdout,
+			Stderr: rewrite(res.Stderr),
+		}
+	}
+
+	//nolint
+	switch e := err.(type) {
+	case *exec.ExitError:
+		re.ExitStatus = e.ExitCode()
+	}
+
+	// Outputs are read even when the command failed, so that it can tell the reason of the failure
+	outputs, readErr := readOutputFile(outputFile)
+	if readErr != nil && err == nil {
+		return re, xerrors.Errorf("reading %s: %w", OutputFileEnv, readErr)
+	}
+
+	re.Outputs = outputs
+
+	return re, err
+}
+
+// executor returns the Executor that runs the commands of the job.
+func (app *App) executor(jobCtx *JobContext) Executor {
+	if jobCtx != nil && jobCtx.executor != nil {
+		return jobCtx.executor
+	}
+
+	if app.Executor != nil {
+		return app.Executor
+	}
+
+	return &localExecutor{app: app}
+}
+
+// namedExecutor returns the Executor registered to App.Executors with the name.
+func (app *App) namedExecutor(name string) (Executor, error) {
+	e, ok := app.Executors[name]
+	if !ok {
+		return nil, fmt.Errorf("executor %q is not registered", name)
+	}
+
+	return e, nil
+}
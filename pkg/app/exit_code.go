@@ -0,0 +1,63 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// Error is the error of a failed command or job, along with the exit code of the variant process.
+type Error struct {
+	Err      error
+	ExitCode int
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ExitCodeOf returns the exit code of the variant process that failed with the error.
+//
+// It is the exit_code_on_failure of the outermost failed job that has one, or the exit status of the failed command.
+// It defaults to 1 when neither is available, like when an assertion or loading a config failed.
+func ExitCodeOf(err error) int {
+	if code, ok := exitCodeOf(err); ok {
+		return code
+	}
+
+	return 1
+}
+
+func exitCodeOf(err error) (int, bool) {
+	var e *Error
+
+	if errors.As(err, &e) {
+		return e.ExitCode, true
+	}
+
+	// Errors of steps and finally blocks are aggregated into multierror, which cannot be unwrapped by errors.As
+	var merr *multierror.Error
+
+	if errors.As(err, &merr) {
+		for _, err := range merr.Errors {
+			if code, ok := exitCodeOf(err); ok {
+				return code, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func validateExitCode(code int) error {
+	if code < 1 || code > 255 {
+		return fmt.Errorf("exit_code_on_failure must be between 1 and 255, but was %d", code)
+	}
+
+	return nil
+}
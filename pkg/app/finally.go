@@ -0,0 +1,52 @@
+package app
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+)
+
+// execFinally runs the `finally` blocks of the job, after the job completed with the result and the error.
+// The result and the error are available as `run.res` and `run.err`, and the results of the steps are available as `step.*`.
+// All the runs are attempted even when some of them failed, and their errors are appended to the error of the job.
+func (app *App) execFinally(l *EventLogger, jobCtx *JobContext, finally []Finally, steps map[string]cty.Value, res *Result, err error, streamOutput bool) (*Result, error) {
+	// Cleanups must run even when the job has been cancelled or timed out
+	ctx := context.Background()
+
+	var errStr string
+
+	if err != nil {
+		errStr = err.Error()
+	}
+
+	finallyCtx := jobCtx.WithVariable("run", cty.ObjectVal(map[string]cty.Value{
+		"res": res.toCty(),
+		"err": cty.StringVal(errStr),
+	}))
+
+	finallyCtx.evalContext.Variables["step"] = cty.ObjectVal(steps)
+
+	var finallyErr *multierror.Error
+
+	for _, f := range finally {
+		for i := range f.Run {
+			r := f.Run[i]
+
+			if _, rErr := app.dispatchRunJob(ctx, l, &finallyCtx, eitherJobRun{static: &r}, streamOutput); rErr != nil {
+				finallyErr = multierror.Append(finallyErr, xerrors.Errorf("finally: %w", rErr))
+			}
+		}
+	}
+
+	if finallyErr == nil {
+		return res, err
+	}
+
+	if err == nil {
+		return res, finallyErr
+	}
+
+	return res, multierror.Append(err, finallyErr.Errors...)
+}
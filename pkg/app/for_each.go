@@ -0,0 +1,96 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// stepInstance is one of the steps expanded from a `for_each` or `matrix` step.
+type stepInstance struct {
+	// key identifies the instance within the results of the step, like `step.<name>[key]`
+	key string
+	// vars are variables available only within the instance, like `each` and `matrix`
+	vars map[string]cty.Value
+}
+
+// forEachElement is an element of the collection given to `for_each`.
+type forEachElement struct {
+	key   string
+	value cty.Value
+}
+
+// toInstance returns the step instance that has the element as the `each` variable.
+func (e forEachElement) toInstance() stepInstance {
+	return stepInstance{
+		key: e.key,
+		vars: map[string]cty.Value{
+			"each": cty.ObjectVal(map[string]cty.Value{
+				"key":   cty.StringVal(e.key),
+				"value": e.value,
+			}),
+		},
+	}
+}
+
+// decodeForEach evaluates the `for_each` expression into step instances ordered by their keys.
+// A map or an object is keyed by its keys, and a list, a set or a tuple of strings is keyed by its values.
+func decodeForEach(expr hcl2.Expression, evalCtx *hcl2.EvalContext) ([]stepInstance, error) {
+	v, diags := expr.Value(evalCtx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	if v.IsNull() || !v.IsWhollyKnown() {
+		return nil, fmt.Errorf("for_each must be a known, non-null value")
+	}
+
+	ty := v.Type()
+
+	var elems []forEachElement
+
+	switch {
+	case ty.IsMapType() || ty.IsObjectType():
+		for it := v.ElementIterator(); it.Next(); {
+			k, ev := it.Element()
+
+			elems = append(elems, forEachElement{key: k.AsString(), value: ev})
+		}
+	case ty.IsListType() || ty.IsSetType() || ty.IsTupleType():
+		seen := map[string]bool{}
+
+		for it := v.ElementIterator(); it.Next(); {
+			_, ev := it.Element()
+
+			if ev.IsNull() || ev.Type() != cty.String {
+				return nil, fmt.Errorf("for_each must be a map or a list of strings, but contained %s", ev.Type().FriendlyName())
+			}
+
+			k := ev.AsString()
+
+			if seen[k] {
+				return nil, fmt.Errorf("for_each contains a duplicate element %q", k)
+			}
+
+			seen[k] = true
+
+			elems = append(elems, forEachElement{key: k, value: ev})
+		}
+	default:
+		return nil, fmt.Errorf("for_each must be a map or a list of strings, but was %s", ty.FriendlyName())
+	}
+
+	sort.Slice(elems, func(i, j int) bool {
+		return elems[i].key < elems[j].key
+	})
+
+	instances := make([]stepInstance, len(elems))
+
+	for i := range elems {
+		instances[i] = elems[i].toInstance()
+	}
+
+	return instances, nil
+}
@@ -7,6 +7,9 @@ import (
 type eitherJobRun struct {
 	static  *StaticRun
 	dynamic *DynamicRun
+
+	// retry is the `retry` block of the enclosing step, if any
+	retry *Retry
 }
 
 type jobRun struct {
@@ -205,9 +205,10 @@ func New(setup Setup, opts ...Option) (*App, error) {
 	nameToFiles, cc, funcs, err := newConfigFromSources(instance.Sources)
 
 	app := &App{
-		Files: nameToFiles,
-		Trace: os.Getenv("VARIANT_TRACE"),
-		Funcs: funcs,
+		Files:    nameToFiles,
+		Trace:    os.Getenv("VARIANT_TRACE"),
+		Funcs:    funcs,
+		CacheDir: options.CacheDir,
 	}
 
 	if err != nil {
@@ -410,6 +411,11 @@ func newApp(app *App, cc *HCL2Config, importDir func(string) (*App, error)) (*Ap
 		conf = cc
 	}
 
+	// The limit of the importing side takes precedence over the imported one
+	if cc.MaxParallel != nil {
+		conf.MaxParallel = cc.MaxParallel
+	}
+
 	app.Config = conf
 
 	app.Config.JobSpec = root
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+	gohcl2 "github.com/hashicorp/hcl/v2/gohcl"
+	"golang.org/x/xerrors"
+)
+
+// lockPollInterval is the interval of the attempts to lock the lock file held by another process.
+const lockPollInterval = 100 * time.Millisecond
+
+// heldLock is the named lock held by a job or a step.
+// The callees of the holder share the lock by taking its nested slot instead of acquiring the lock again,
+// so that the holder can call the job requiring the same lock while the steps sharing the lock run one at a time.
+type heldLock struct {
+	nested chan struct{}
+}
+
+func (app *App) lockDir() string {
+	dir := app.CacheDir
+	if dir == "" {
+		dir = DefaultCacheDir
+	}
+
+	return filepath.Join(dir, "locks")
+}
+
+// withLock acquires the named lock for the job or the step, and returns the job context for running it and the func
+// to release the lock. The lock is a no-op when the expression is empty.
+// When the lock is already held by a caller, only the nested slot of the caller is taken.
+func (app *App) withLock(ctx context.Context, jobCtx *JobContext, lock, lockTimeout hcl2.Expression) (*JobContext, func(), error) {
+	noop := func() {}
+
+	if lock == nil || IsExpressionEmpty(lock) {
+		return jobCtx, noop, nil
+	}
+
+	var name string
+
+	if diags := gohcl2.DecodeExpression(lock, jobCtx.evalContext, &name); diags.HasErrors() {
+		return nil, nil, xerrors.Errorf("lock: %w", diags)
+	}
+
+	if name == "" {
+		return nil, nil, fmt.Errorf("lock: the name must not be empty")
+	}
+
+	var timeout time.Duration
+
+	if lockTimeout != nil && !IsExpressionEmpty(lockTimeout) {
+		d, err := decodeDuration(lockTimeout, jobCtx.evalContext)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("lock_timeout: %w", err)
+		}
+
+		timeout = d
+	}
+
+	var (
+		release func()
+		err     error
+	)
+
+	// The lock is reentrant only for the holder, so that the steps of the job holding the lock still run one at a time
+	if held, ok := jobCtx.locks[name]; ok {
+		release, err = app.waitLocal(ctx, jobCtx, held.nested, name, timeout)
+	} else {
+		release, err = app.acquireLock(ctx, jobCtx, name, timeout)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	locks := map[string]*heldLock{name: {nested: make(chan struct{}, 1)}}
+
+	for n, l := range jobCtx.locks {
+		if n != name {
+			locks[n] = l
+		}
+	}
+
+	c := *jobCtx
+	c.locks = locks
+
+	return &c, release, nil
+}
+
+// acquireLock waits for the named lock to be released by the other steps of this process, and then by other processes.
+// Zero timeout means that it waits until the context is done.
+func (app *App) acquireLock(ctx context.Context, jobCtx *JobContext, name string, timeout time.Duration) (func(), error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	app.locksMutex.Lock()
+
+	if app.locks == nil {
+		app.locks = map[string]chan struct{}{}
+	}
+
+	ch, ok := app.locks[name]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		app.locks[name] = ch
+	}
+
+	app.locksMutex.Unlock()
+
+	unlockLocal, waiting, err := app.lockLocal(ctx, jobCtx, ch, name, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(app.lockDir(), 0755); err != nil {
+		unlockLocal()
+
+		return nil, xerrors.Errorf("creating lock directory: %w", err)
+	}
+
+	path := filepath.Join(app.lockDir(), url.PathEscape(name)+".lock")
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		unlockLocal()
+
+		return nil, xerrors.Errorf("opening lock file: %w", err)
+	}
+
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			unlockLocal()
+
+			return nil, xerrors.Errorf("locking %s: %w", path, err)
+		}
+
+		if locked {
+			break
+		}
+
+		if !waiting {
+			waiting = true
+
+			fmt.Fprintf(app.stderr(jobCtx), "waiting for lock %q held by another process\n", name)
+		}
+
+		select {
+		case <-time.After(lockPollInterval):
+		case <-ctx.Done():
+			f.Close()
+			unlockLocal()
+
+			return nil, lockWaitErr(ctx, name, timeout)
+		}
+	}
+
+	return func() {
+		_ = unlockFile(f)
+		f.Close()
+		unlockLocal()
+	}, nil
+}
+
+// waitLocal waits for the in-process lock, without locking the lock file. Zero timeout means that it waits until the
+// context is done.
+func (app *App) waitLocal(ctx context.Context, jobCtx *JobContext, ch chan struct{}, name string, timeout time.Duration) (func(), error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	unlock, _, err := app.lockLocal(ctx, jobCtx, ch, name, timeout)
+
+	return unlock, err
+}
+
+// lockLocal takes the slot of the in-process lock, and tells if it had to wait for another step to release it.
+func (app *App) lockLocal(ctx context.Context, jobCtx *JobContext, ch chan struct{}, name string, timeout time.Duration) (func(), bool, error) {
+	unlock := func() { <-ch }
+
+	select {
+	case ch <- struct{}{}:
+		return unlock, false, nil
+	default:
+	}
+
+	fmt.Fprintf(app.stderr(jobCtx), "waiting for lock %q held by another step\n", name)
+
+	select {
+	case ch <- struct{}{}:
+		return unlock, true, nil
+	case <-ctx.Done():
+		return nil, true, lockWaitErr(ctx, name, timeout)
+	}
+}
+
+func lockWaitErr(ctx context.Context, name string, timeout time.Duration) error {
+	if timeout > 0 && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v waiting for lock %q", timeout, name)
+	}
+
+	return xerrors.Errorf("waiting for lock %q: %w", name, ctx.Err())
+}
+
+// runLockedStep runs the step while holding its lock, if any.
+func (app *App) runLockedStep(ctx context.Context, l *EventLogger, jobCtx *JobContext, s Step, m *sync.Mutex, streamOutput bool) (*Result, error) {
+	// Nothing is run in the dry-run mode
+	if jobCtx.execMatcher == nil || !jobCtx.execMatcher.record {
+		lockedCtx, release, err := app.withLock(ctx, jobCtx, s.Lock, s.LockTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		defer release()
+
+		jobCtx = lockedCtx
+	}
+
+	return app.runJobAndUpdateContext(ctx, l, jobCtx, eitherJobRun{static: &s.Run, retry: s.Retry}, m, streamOutput)
+}
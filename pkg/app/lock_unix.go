@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package app
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLockFile tries to acquire the exclusive lock of the file without blocking, and tells if it succeeded.
+func tryLockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package app
+
+import (
+	"os"
+)
+
+// tryLockFile always succeeds, as locks are not held across processes on Windows.
+func tryLockFile(_ *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(_ *os.File) error {
+	return nil
+}
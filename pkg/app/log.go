@@ -12,10 +12,11 @@ import (
 )
 
 type Event struct {
-	Type string
-	Time time.Time
-	Run  *RunEvent
-	Exec *ExecEvent
+	Type  string
+	Time  time.Time
+	Run   *RunEvent
+	Exec  *ExecEvent
+	Retry *RetryEvent
 }
 
 type RunEvent struct {
@@ -26,6 +27,16 @@ type RunEvent struct {
 type ExecEvent struct {
 	Command string
 	Args    []string
+	// Reason is why the exec was skipped. Set only for `exec:skipped` events
+	Reason string
+}
+
+type RetryEvent struct {
+	// Attempt is the number of the attempt to be made, starting from 2 for the first retry
+	Attempt int
+	Delay   time.Duration
+	// Err is the error of the last attempt
+	Err string
 }
 
 func (evt Event) toCty() cty.Value {
@@ -42,9 +53,85 @@ func (evt Event) toCty() cty.Value {
 		m["exec"] = evt.Exec.toCty()
 	}
 
+	if evt.Retry != nil {
+		m["retry"] = evt.Retry.toCty()
+	}
+
 	return cty.ObjectVal(m)
 }
 
+// sanitize returns a copy of the event whose strings are masked by f.
+func (evt Event) sanitize(f func(string) string) Event {
+	if evt.Run != nil {
+		args := make(map[string]interface{}, len(evt.Run.Args))
+
+		for k, v := range evt.Run.Args {
+			args[k] = sanitizeArg(v, f)
+		}
+
+		evt.Run = &RunEvent{Job: evt.Run.Job, Args: args}
+	}
+
+	if evt.Exec != nil {
+		args := make([]string, len(evt.Exec.Args))
+
+		for i, a := range evt.Exec.Args {
+			args[i] = f(a)
+		}
+
+		evt.Exec = &ExecEvent{Command: f(evt.Exec.Command), Args: args, Reason: f(evt.Exec.Reason)}
+	}
+
+	if evt.Retry != nil {
+		r := *evt.Retry
+		r.Err = f(r.Err)
+		evt.Retry = &r
+	}
+
+	return evt
+}
+
+func sanitizeArg(v interface{}, f func(string) string) interface{} {
+	switch typed := v.(type) {
+	case string:
+		return f(typed)
+	case []string:
+		vs := make([]string, len(typed))
+
+		for i, s := range typed {
+			vs[i] = f(s)
+		}
+
+		return vs
+	case []interface{}:
+		vs := make([]interface{}, len(typed))
+
+		for i, e := range typed {
+			vs[i] = sanitizeArg(e, f)
+		}
+
+		return vs
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(typed))
+
+		for k, e := range typed {
+			m[k] = sanitizeArg(e, f)
+		}
+
+		return m
+	case map[string]string:
+		m := make(map[string]string, len(typed))
+
+		for k, s := range typed {
+			m[k] = f(s)
+		}
+
+		return m
+	}
+
+	return v
+}
+
 func (e *RunEvent) toCty() cty.Value {
 	var args cty.Value
 
@@ -72,9 +159,23 @@ func (e *ExecEvent) toCty() cty.Value {
 		vals = append(vals, cty.StringVal(a))
 	}
 
-	return cty.ObjectVal(map[string]cty.Value{
+	m := map[string]cty.Value{
 		"command": cty.StringVal(e.Command),
 		"args":    cty.ListVal(vals),
+	}
+
+	if e.Reason != "" {
+		m["reason"] = cty.StringVal(e.Reason)
+	}
+
+	return cty.ObjectVal(m)
+}
+
+func (e *RetryEvent) toCty() cty.Value {
+	return cty.ObjectVal(map[string]cty.Value{
+		"attempt": cty.NumberIntVal(int64(e.Attempt)),
+		"delay":   cty.StringVal(e.Delay.String()),
+		"err":     cty.StringVal(e.Err),
 	})
 }
 
@@ -88,6 +189,9 @@ type EventLogger struct {
 
 	Stderr io.Writer
 
+	// Sanitize masks secrets in events and collected logs, when set
+	Sanitize func(string) string
+
 	Events []Event
 
 	collectors map[int]*LogCollector
@@ -120,7 +224,29 @@ func (l *EventLogger) LogExec(cmd string, args []string) error {
 	}})
 }
 
+// LogExecSkipped logs the exec skipped by its `creates`, `removes` or `unless` guard.
+func (l *EventLogger) LogExecSkipped(cmd string, args []string, reason string) error {
+	return l.append(Event{Type: "exec:skipped", Time: time.Now(), Exec: &ExecEvent{
+		Command: cmd,
+		Args:    args,
+		Reason:  reason,
+	}})
+}
+
+// LogRetry logs the retry of the run or the exec.
+func (l *EventLogger) LogRetry(run *RunEvent, exec *ExecEvent, attempt int, delay time.Duration, lastErr error) error {
+	return l.append(Event{Type: "run:retry", Time: time.Now(), Run: run, Exec: exec, Retry: &RetryEvent{
+		Attempt: attempt,
+		Delay:   delay,
+		Err:     lastErr.Error(),
+	}})
+}
+
 func (l *EventLogger) append(evt Event) error {
+	if l.Sanitize != nil {
+		evt = evt.sanitize(l.Sanitize)
+	}
+
 	l.eventsMutex.Lock()
 	l.Events = append(l.Events, evt)
 	l.eventsMutex.Unlock()
@@ -136,7 +262,7 @@ func (l *EventLogger) append(evt Event) error {
 
 		// Non-nil line means that any collect block's condition matched the logged event
 		if line != nil && l.Stream == "stderr" {
-			if _, err := l.Stderr.Write([]byte(*line + "\n")); err != nil {
+			if _, err := l.Stderr.Write([]byte(l.sanitize(*line) + "\n")); err != nil {
 				return xerrors.Errorf("wrirting stderr: %w", err)
 			}
 		}
@@ -145,6 +271,14 @@ func (l *EventLogger) append(evt Event) error {
 	return nil
 }
 
+func (l *EventLogger) sanitize(s string) string {
+	if l.Sanitize == nil {
+		return s
+	}
+
+	return l.Sanitize(s)
+}
+
 func (l *EventLogger) Register(logCollector LogCollector) func() error {
 	id := l.lastIndex + 1
 	l.lastIndex = id
@@ -170,7 +304,7 @@ func (l *EventLogger) Register(logCollector LogCollector) func() error {
 		}
 
 		//nolint:gosec
-		if err := ioutil.WriteFile(file, []byte(strings.Join(logCollector.lines, "\n")), 0o644); err != nil {
+		if err := ioutil.WriteFile(file, []byte(l.sanitize(strings.Join(logCollector.lines, "\n"))), 0o644); err != nil {
 			return xerrors.Errorf("writing %s: %w", file, err)
 		}
 
@@ -0,0 +1,273 @@
+package app
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"golang.org/x/xerrors"
+)
+
+// expandStep expands the `for_each` or `matrix` step into step instances.
+func expandStep(s Step, evalCtx *hcl2.EvalContext) ([]stepInstance, error) {
+	if s.Matrix != nil {
+		if !IsExpressionEmpty(s.ForEach) {
+			return nil, fmt.Errorf("for_each and matrix can not be used together")
+		}
+
+		instances, err := decodeMatrix(s.Matrix, evalCtx)
+		if err != nil {
+			return nil, xerrors.Errorf("matrix: %w", err)
+		}
+
+		return instances, nil
+	}
+
+	instances, err := decodeForEach(s.ForEach, evalCtx)
+	if err != nil {
+		return nil, xerrors.Errorf("for_each: %w", err)
+	}
+
+	return instances, nil
+}
+
+// matrixDimension is a dimension of the matrix, like `env = ["dev", "prd"]`.
+type matrixDimension struct {
+	name   string
+	values []cty.Value
+}
+
+// matrixCombination is a combination of values, one for each dimension.
+type matrixCombination struct {
+	values map[string]cty.Value
+}
+
+// decodeMatrix evaluates the `matrix` block into step instances, one for each combination of dimension values.
+// Each instance is keyed by the values joined by "/" in the order of the dimensions declared, like "prd/eu".
+func decodeMatrix(mat *Matrix, evalCtx *hcl2.EvalContext) ([]stepInstance, error) {
+	dims, err := decodeMatrixDimensions(mat.Dimensions, evalCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	dimNames := map[string]bool{}
+	for _, d := range dims {
+		dimNames[d.name] = true
+	}
+
+	excludes, err := decodeMatrixCombinations("exclude", mat.Exclude, evalCtx, dimNames, false)
+	if err != nil {
+		return nil, err
+	}
+
+	includes, err := decodeMatrixCombinations("include", mat.Include, evalCtx, dimNames, true)
+	if err != nil {
+		return nil, err
+	}
+
+	combos := []matrixCombination{{values: map[string]cty.Value{}}}
+
+	for _, d := range dims {
+		var next []matrixCombination
+
+		for _, c := range combos {
+			for _, v := range d.values {
+				values := map[string]cty.Value{}
+
+				for k, cv := range c.values {
+					values[k] = cv
+				}
+
+				values[d.name] = v
+
+				next = append(next, matrixCombination{values: values})
+			}
+		}
+
+		combos = next
+	}
+
+	var instances []stepInstance
+
+	seen := map[string]bool{}
+
+	add := func(c matrixCombination) error {
+		var keys []string
+
+		for _, d := range dims {
+			s, err := matrixValueString(c.values[d.name])
+			if err != nil {
+				return xerrors.Errorf("%s: %w", d.name, err)
+			}
+
+			keys = append(keys, s)
+		}
+
+		key := strings.Join(keys, "/")
+
+		if seen[key] {
+			return nil
+		}
+
+		seen[key] = true
+
+		instances = append(instances, stepInstance{
+			key: key,
+			vars: map[string]cty.Value{
+				"matrix": cty.ObjectVal(c.values),
+			},
+		})
+
+		return nil
+	}
+
+	for _, c := range combos {
+		if c.matchesAny(excludes) {
+			continue
+		}
+
+		if err := add(c); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range includes {
+		if err := add(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return instances, nil
+}
+
+func decodeMatrixDimensions(attrs hcl2.Attributes, evalCtx *hcl2.EvalContext) ([]matrixDimension, error) {
+	var names []string
+
+	for name := range attrs {
+		names = append(names, name)
+	}
+
+	// Preserve the order of definitions, so that the keys of combinations are predictable
+	sort.Slice(names, func(i, j int) bool {
+		return attrs[names[i]].Range.Start.Byte < attrs[names[j]].Range.Start.Byte
+	})
+
+	var dims []matrixDimension
+
+	for _, name := range names {
+		v, diags := attrs[name].Expr.Value(evalCtx)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+
+		ty := v.Type()
+
+		if v.IsNull() || !v.IsWhollyKnown() || !(ty.IsListType() || ty.IsSetType() || ty.IsTupleType()) {
+			return nil, fmt.Errorf("dimension %q must be a list, but was %s", name, ty.FriendlyName())
+		}
+
+		d := matrixDimension{name: name}
+
+		for it := v.ElementIterator(); it.Next(); {
+			_, ev := it.Element()
+
+			if _, err := matrixValueString(ev); err != nil {
+				return nil, xerrors.Errorf("dimension %q: %w", name, err)
+			}
+
+			d.values = append(d.values, ev)
+		}
+
+		dims = append(dims, d)
+	}
+
+	return dims, nil
+}
+
+// decodeMatrixCombinations decodes `exclude` or `include` as a list of objects keyed by dimension names.
+// When complete is true, each object must have values for all the dimensions.
+func decodeMatrixCombinations(attr string, expr hcl2.Expression, evalCtx *hcl2.EvalContext, dimNames map[string]bool, complete bool) ([]matrixCombination, error) {
+	if IsExpressionEmpty(expr) {
+		return nil, nil
+	}
+
+	v, diags := expr.Value(evalCtx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	ty := v.Type()
+
+	if v.IsNull() || !v.IsWhollyKnown() || !(ty.IsListType() || ty.IsSetType() || ty.IsTupleType()) {
+		return nil, fmt.Errorf("%s must be a list of objects, but was %s", attr, ty.FriendlyName())
+	}
+
+	var combos []matrixCombination
+
+	for it := v.ElementIterator(); it.Next(); {
+		_, ev := it.Element()
+
+		ety := ev.Type()
+
+		if ev.IsNull() || !(ety.IsObjectType() || ety.IsMapType()) {
+			return nil, fmt.Errorf("%s must be a list of objects, but contained %s", attr, ety.FriendlyName())
+		}
+
+		values := ev.AsValueMap()
+
+		for k := range values {
+			if !dimNames[k] {
+				return nil, fmt.Errorf("%s: unknown dimension %q", attr, k)
+			}
+		}
+
+		if complete && len(values) != len(dimNames) {
+			return nil, fmt.Errorf("%s: every dimension must be given a value, but got %d out of %d", attr, len(values), len(dimNames))
+		}
+
+		combos = append(combos, matrixCombination{values: values})
+	}
+
+	return combos, nil
+}
+
+// matchesAny tells if all the values of any of the partial combinations equal to the values of the combination.
+func (c matrixCombination) matchesAny(partials []matrixCombination) bool {
+	for _, p := range partials {
+		matched := true
+
+		for k, v := range p.values {
+			a, aErr := matrixValueString(c.values[k])
+			b, bErr := matrixValueString(v)
+
+			if aErr != nil || bErr != nil || a != b {
+				matched = false
+
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matrixValueString returns the string representation of the dimension value used in the key of the combination.
+func matrixValueString(v cty.Value) (string, error) {
+	if v.IsNull() || !v.IsKnown() {
+		return "", fmt.Errorf("values must be known, non-null strings")
+	}
+
+	s, err := convert.Convert(v, cty.String)
+	if err != nil {
+		return "", fmt.Errorf("values must be strings, numbers or bools, but got %s", v.Type().FriendlyName())
+	}
+
+	return s.AsString(), nil
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/xerrors"
+)
+
+// evaluateOutputs evaluates the `output` blocks of the job against the result of the job.
+// The value of each output can refer to the result as `res`, the results of the steps as `step`,
+// and the stdout parsed as JSON as `json`. The value is converted to the type of the output, if any.
+func evaluateOutputs(jobEvalCtx *hcl2.EvalContext, specs []OutputSpec, res *Result, steps map[string]cty.Value) (map[string]cty.Value, error) {
+	evalCtx := cloneEvalContext(jobEvalCtx)
+	evalCtx.Variables["res"] = res.toCty()
+	evalCtx.Variables["step"] = cty.ObjectVal(steps)
+
+	outputs := map[string]cty.Value{}
+
+	for _, o := range specs {
+		if _, ok := evalCtx.Variables["json"]; !ok && refersTo(o.Value, "json") {
+			v, err := parseJSON(res.Stdout)
+			if err != nil {
+				return nil, xerrors.Errorf("output %q: parsing stdout as json: %w", o.Name, err)
+			}
+
+			evalCtx.Variables["json"] = v
+		}
+
+		v, diags := o.Value.Value(evalCtx)
+		if diags.HasErrors() {
+			return nil, xerrors.Errorf("output %q: %w", o.Name, diags)
+		}
+
+		if o.Type != nil && !IsExpressionEmpty(o.Type) {
+			tpe, diags := typeexpr.TypeConstraint(o.Type)
+			if diags.HasErrors() {
+				return nil, xerrors.Errorf("output %q: type: %w", o.Name, diags)
+			}
+
+			converted, err := convert.Convert(v, tpe)
+			if err != nil {
+				return nil, fmt.Errorf("output %q: unexpected type of value. want %q, got %q: %v", o.Name, tpe.FriendlyNameForConstraint(), v.Type().FriendlyName(), err)
+			}
+
+			v = converted
+		}
+
+		outputs[o.Name] = v
+	}
+
+	return outputs, nil
+}
+
+func refersTo(expr hcl2.Expression, name string) bool {
+	for _, t := range expr.Variables() {
+		if t.RootName() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseJSON(s string) (cty.Value, error) {
+	src := []byte(s)
+
+	tpe, err := ctyjson.ImpliedType(src)
+	if err != nil {
+		return cty.NilVal, err
+	}
+
+	return ctyjson.Unmarshal(src, tpe)
+}
+
+func outputsToCty(outputs map[string]cty.Value) cty.Value {
+	if len(outputs) == 0 {
+		return cty.EmptyObjectVal
+	}
+
+	return cty.ObjectVal(outputs)
+}
+
+// storedOutputs is the outputs of the job persisted along with the type, so that they are restored as they were.
+type storedOutputs map[string]cty.Value
+
+type storedOutputsJSON struct {
+	Type  json.RawMessage `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (o storedOutputs) MarshalJSON() ([]byte, error) {
+	v := cty.ObjectVal(o)
+
+	tpe, err := ctyjson.MarshalType(v.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := ctyjson.Marshal(v, v.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(storedOutputsJSON{Type: tpe, Value: val})
+}
+
+func (o *storedOutputs) UnmarshalJSON(data []byte) error {
+	var j storedOutputsJSON
+
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	tpe, err := ctyjson.UnmarshalType(j.Type)
+	if err != nil {
+		return err
+	}
+
+	v, err := ctyjson.Unmarshal(j.Value, tpe)
+	if err != nil {
+		return err
+	}
+
+	*o = v.AsValueMap()
+
+	return nil
+}
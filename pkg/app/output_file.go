@@ -0,0 +1,95 @@
+package app
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// OutputFileEnv is the name of the envvar given to every command, which is the path to the file the command writes
+// its outputs to. Each output is either a line like `name=value`, or lines like below for a multi-line value:
+//
+//	name<<EOS
+//	line 1
+//	line 2
+//	EOS
+const OutputFileEnv = "VARIANT_OUTPUT"
+
+// createOutputFile creates an empty file for the command to write its outputs to.
+// The returned func removes the file.
+func createOutputFile() (string, func(), error) {
+	f, err := ioutil.TempFile("", "variant-output-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	remove := func() {
+		os.Remove(f.Name())
+	}
+
+	if err := f.Close(); err != nil {
+		remove()
+
+		return "", nil, err
+	}
+
+	return f.Name(), remove, nil
+}
+
+// readOutputFile reads the outputs written by the command to the file.
+func readOutputFile(path string) (map[string]cty.Value, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseOutputs(string(bs))
+}
+
+func parseOutputs(s string) (map[string]cty.Value, error) {
+	outputs := map[string]cty.Value{}
+
+	lines := strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n", "\n"), "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if line == "" {
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		heredoc := strings.Index(line, "<<")
+
+		switch {
+		case eq > 0 && (heredoc < 0 || eq < heredoc):
+			outputs[line[:eq]] = cty.StringVal(line[eq+1:])
+		case heredoc > 0:
+			name, delim := line[:heredoc], line[heredoc+2:]
+			if delim == "" {
+				return nil, fmt.Errorf("line %d: missing delimiter after %q", i+1, line)
+			}
+
+			var value []string
+
+			start := i
+
+			for i++; i < len(lines) && lines[i] != delim; i++ {
+				value = append(value, lines[i])
+			}
+
+			if i == len(lines) {
+				return nil, fmt.Errorf("line %d: delimiter %q of %q is not found", start+1, delim, name)
+			}
+
+			outputs[name] = cty.StringVal(strings.Join(value, "\n"))
+		default:
+			return nil, fmt.Errorf("line %d: %q must be either like `name=value` or `name<<DELIMITER`", i+1, line)
+		}
+	}
+
+	return outputs, nil
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestParseOutputs(t *testing.T) {
+	type testcase struct {
+		subject string
+		input   string
+		want    map[string]string
+		err     string
+	}
+
+	testcases := []testcase{
+		{
+			subject: "key-value pairs",
+			input:   "a=1\nb=x=y\n\nc=\n",
+			want:    map[string]string{"a": "1", "b": "x=y", "c": ""},
+		},
+		{
+			subject: "heredoc",
+			input:   "notes<<EOS\nline 1\n\nline=3\nEOS\nafter=1\n",
+			want:    map[string]string{"notes": "line 1\n\nline=3", "after": "1"},
+		},
+		{
+			subject: "later one wins",
+			input:   "a=1\na=2",
+			want:    map[string]string{"a": "2"},
+		},
+		{
+			subject: "missing delimiter",
+			input:   "notes<<EOS\nline 1\n",
+			err:     `line 1: delimiter "EOS" of "notes" is not found`,
+		},
+		{
+			subject: "invalid line",
+			input:   "a=1\nfoo\n",
+			err:     "line 2: \"foo\" must be either like `name=value` or `name<<DELIMITER`",
+		},
+	}
+
+	for i := range testcases {
+		tc := testcases[i]
+
+		t.Run(tc.subject, func(t *testing.T) {
+			got, err := parseOutputs(tc.input)
+
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("unexpected error: want %q, got %v", tc.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("unexpected outputs: want %v, got %v", tc.want, got)
+			}
+
+			for k, v := range tc.want {
+				if !got[k].RawEquals(cty.StringVal(v)) {
+					t.Errorf("unexpected output %q: want %q, got %#v", k, v, got[k])
+				}
+			}
+		})
+	}
+}
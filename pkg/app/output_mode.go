@@ -0,0 +1,164 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	// OutputModeRaw writes the output of the commands run by steps as is. Lines from concurrent steps may interleave
+	OutputModeRaw = "raw"
+	// OutputModePrefixed prefixes each line of the output of the commands run by a step with the step name
+	OutputModePrefixed = "prefixed"
+	// OutputModeGrouped buffers the output of the commands run by a step, and writes it as a block once the step finishes
+	OutputModeGrouped = "grouped"
+)
+
+func validateOutputMode(mode string) error {
+	switch mode {
+	case "", OutputModeRaw, OutputModePrefixed, OutputModeGrouped:
+		return nil
+	}
+
+	return fmt.Errorf("output mode %q is not supported. It must be either %q, %q or %q", mode, OutputModeRaw, OutputModePrefixed, OutputModeGrouped)
+}
+
+// stdout returns the writer the output of the commands run within the job context is streamed to.
+func (app *App) stdout(jobCtx *JobContext) io.Writer {
+	if jobCtx != nil && jobCtx.stdout != nil {
+		return jobCtx.stdout
+	}
+
+	return app.Stdout
+}
+
+// stderr returns the writer the error output of the commands run within the job context is streamed to.
+func (app *App) stderr(jobCtx *JobContext) io.Writer {
+	if jobCtx != nil && jobCtx.stderr != nil {
+		return jobCtx.stderr
+	}
+
+	return app.Stderr
+}
+
+// withStepOutput returns the job context for running the step, whose output is written according to App.OutputMode.
+// The returned func must be called once the step finishes, to write the output buffered in the grouped mode.
+func (app *App) withStepOutput(jobCtx *JobContext, name string) (*JobContext, func() error) {
+	stdout, stderr := app.stdout(jobCtx), app.stderr(jobCtx)
+
+	// Writes to App.Stdout and App.Stderr from concurrent steps are serialized, so that lines from them never mix.
+	// Writes from nested steps are serialized by the writers of the enclosing step.
+	var mu *sync.Mutex
+
+	if jobCtx.stdout == nil {
+		mu = &app.outputMutex
+	}
+
+	switch app.OutputMode {
+	case OutputModePrefixed:
+		c := *jobCtx
+		c.stdout = &prefixWriter{prefix: fmt.Sprintf("[%s] ", name), w: stdout, mu: mu}
+		c.stderr = &prefixWriter{prefix: fmt.Sprintf("[%s] ", name), w: stderr, mu: mu}
+
+		return &c, func() error { return nil }
+	case OutputModeGrouped:
+		g := &outputGroup{header: fmt.Sprintf("==> %s\n", name), mu: mu}
+
+		c := *jobCtx
+		c.stdout = &groupWriter{group: g, w: stdout}
+		c.stderr = &groupWriter{group: g, w: stderr}
+
+		return &c, g.flush
+	}
+
+	return jobCtx, func() error { return nil }
+}
+
+// prefixWriter prefixes each line written to it.
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	var buf bytes.Buffer
+
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line == "" {
+			continue
+		}
+
+		buf.WriteString(w.prefix)
+		buf.WriteString(line)
+	}
+
+	if w.mu != nil {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+	}
+
+	if _, err := w.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// outputGroup buffers the writes to the stdout and the stderr of a step in order.
+type outputGroup struct {
+	header string
+	writes []groupedWrite
+	mu     *sync.Mutex
+	bufMu  sync.Mutex
+}
+
+type groupedWrite struct {
+	w io.Writer
+	p []byte
+}
+
+func (g *outputGroup) flush() error {
+	g.bufMu.Lock()
+	writes := g.writes
+	g.writes = nil
+	g.bufMu.Unlock()
+
+	if len(writes) == 0 {
+		return nil
+	}
+
+	if g.mu != nil {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+	}
+
+	if _, err := io.WriteString(writes[0].w, g.header); err != nil {
+		return err
+	}
+
+	for _, wr := range writes {
+		if _, err := wr.w.Write(wr.p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type groupWriter struct {
+	group *outputGroup
+	w     io.Writer
+}
+
+func (w *groupWriter) Write(p []byte) (int, error) {
+	w.group.bufMu.Lock()
+	defer w.group.bufMu.Unlock()
+
+	w.group.writes = append(w.group.writes, groupedWrite{w: w.w, p: append([]byte{}, p...)})
+
+	return len(p), nil
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"context"
+	"fmt"
+)
+
+// semaphore limits the number of commands run at the same time across the whole run, including the ones run by
+// nested jobs and `depends_on` items. The nil semaphore imposes no limit.
+type semaphore chan struct{}
+
+// newRunSemaphore returns the semaphore for the run, according to App.MaxParallel or the `max_parallel` attribute.
+func (app *App) newRunSemaphore() (semaphore, error) {
+	n := app.MaxParallel
+
+	if n < 0 {
+		return nil, fmt.Errorf("max parallel %d is invalid: it must be greater than 0", n)
+	}
+
+	if n == 0 && app.Config != nil && app.Config.MaxParallel != nil {
+		n = *app.Config.MaxParallel
+
+		if n < 1 {
+			return nil, fmt.Errorf("max_parallel %d is invalid: it must be greater than 0", n)
+		}
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	return make(semaphore, n), nil
+}
+
+// acquire waits for a slot to run a command, and returns the func to release it.
+func (s semaphore) acquire(ctx context.Context) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return func() { <-s }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,245 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"golang.org/x/xerrors"
+)
+
+// CachedResult describes a result of a job stored in the result cache.
+type CachedResult struct {
+	// Key is the hash of the job name, the args and the cache keys of the job run
+	Key       string
+	Job       string
+	CreatedAt time.Time
+	// ExpiresAt is zero when the result never expires
+	ExpiresAt time.Time
+	// Broken is true when the stored result can not be parsed, in which case Job and the times are unknown.
+	// The broken result is never used and is overwritten by the next run of the job.
+	Broken bool
+}
+
+type resultCacheEntry struct {
+	Job       string       `json:"job"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt time.Time    `json:"expires_at,omitempty"`
+	Result    storedResult `json:"result"`
+}
+
+func (app *App) resultCacheDir() string {
+	dir := app.CacheDir
+	if dir == "" {
+		dir = DefaultCacheDir
+	}
+
+	return filepath.Join(dir, "results")
+}
+
+// resultCacheKey returns the hash of the job name, the resolved params and options, and the value of the optional key.
+func resultCacheKey(job string, key hcl2.Expression, evalCtx *hcl2.EvalContext) (string, error) {
+	values := map[string]cty.Value{
+		"job":   cty.StringVal(job),
+		"param": evalCtx.Variables["param"],
+		"opt":   evalCtx.Variables["opt"],
+	}
+
+	if key != nil && !IsExpressionEmpty(key) {
+		v, diags := key.Value(evalCtx)
+		if diags.HasErrors() {
+			return "", diags
+		}
+
+		values["key"] = v
+	}
+
+	for k, v := range values {
+		if v == cty.NilVal {
+			delete(values, k)
+		}
+	}
+
+	obj := cty.ObjectVal(values)
+
+	bs, err := ctyjson.Marshal(obj, obj.Type())
+	if err != nil {
+		return "", xerrors.Errorf("marshalling cache key: %w", err)
+	}
+
+	sum := sha256.Sum256(bs)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// getCachedResult returns the fresh result cached under the key if any.
+func (app *App) getCachedResult(key string) (*Result, bool, error) {
+	bs, err := ioutil.ReadFile(filepath.Join(app.resultCacheDir(), key+".json"))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	var e resultCacheEntry
+
+	if err := json.Unmarshal(bs, &e); err != nil {
+		// A broken entry is treated as missing, so that it is overwritten by the next run
+		return nil, false, nil
+	}
+
+	if !e.ExpiresAt.IsZero() && time.Now().After(e.ExpiresAt) {
+		return nil, false, nil
+	}
+
+	return e.Result.toResult(), true, nil
+}
+
+func (app *App) putCachedResult(key, job string, ttl time.Duration, res *Result) error {
+	now := time.Now()
+
+	e := resultCacheEntry{
+		Job:       job,
+		CreatedAt: now,
+		Result:    newStoredResult(res),
+	}
+
+	if ttl > 0 {
+		e.ExpiresAt = now.Add(ttl)
+	}
+
+	bs, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	dir := app.resultCacheDir()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, key+".json")
+
+	// Write to a temporary file first, so that concurrent runs never read a half-written entry
+	tmp := path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, bs, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// ListCachedResults returns the results stored in the result cache, ordered by their creation times.
+func (app *App) ListCachedResults() ([]CachedResult, error) {
+	dir := app.resultCacheDir()
+
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var results []CachedResult
+
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+
+		bs, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var e resultCacheEntry
+
+		key := strings.TrimSuffix(f.Name(), ".json")
+
+		if err := json.Unmarshal(bs, &e); err != nil {
+			results = append(results, CachedResult{Key: key, Broken: true})
+
+			continue
+		}
+
+		results = append(results, CachedResult{
+			Key:       key,
+			Job:       e.Job,
+			CreatedAt: e.CreatedAt,
+			ExpiresAt: e.ExpiresAt,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CreatedAt.Before(results[j].CreatedAt)
+	})
+
+	return results, nil
+}
+
+// ClearCachedResults removes the results of the jobs from the result cache, or all the results when no job is given.
+// Broken results are always removed, as they can not be told which job they belong to.
+// It returns the number of removed results.
+func (app *App) ClearCachedResults(jobs ...string) (int, error) {
+	results, err := app.ListCachedResults()
+	if err != nil {
+		return 0, err
+	}
+
+	targets := map[string]bool{}
+	for _, j := range jobs {
+		targets[j] = true
+	}
+
+	var n int
+
+	for _, r := range results {
+		if len(targets) > 0 && !targets[r.Job] && !r.Broken {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(app.resultCacheDir(), r.Key+".json")); err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+func (app *App) replayOutput(res *Result) {
+	for _, o := range []struct {
+		w   io.Writer
+		out string
+	}{
+		{app.Stdout, res.Stdout},
+		{app.Stderr, res.Stderr},
+	} {
+		if o.w == nil || o.out == "" {
+			continue
+		}
+
+		// The cached result contains secrets as they are
+		out := app.sanitize(o.out)
+
+		fmt.Fprint(o.w, out)
+
+		if !strings.HasSuffix(out, "\n") {
+			fmt.Fprintln(o.w)
+		}
+	}
+}
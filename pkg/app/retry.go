@@ -0,0 +1,193 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+	gohcl2 "github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/zclconf/go-cty/cty"
+	"golang.org/x/xerrors"
+)
+
+const (
+	BackoffConstant    = "constant"
+	BackoffExponential = "exponential"
+)
+
+// DefaultRetryMaxDelay is the cap of the delay doubled by the exponential backoff, unless `max_delay` is set.
+// The delay longer than this is used as it is.
+const DefaultRetryMaxDelay = 5 * time.Minute
+
+type retryPolicy struct {
+	attempts int
+	delay    time.Duration
+	maxDelay time.Duration
+	backoff  string
+	retryOn  hcl2.Expression
+}
+
+func newRetryPolicy(spec *Retry, evalCtx *hcl2.EvalContext) (*retryPolicy, error) {
+	p := &retryPolicy{
+		attempts: 1,
+		backoff:  BackoffConstant,
+	}
+
+	if spec == nil {
+		return p, nil
+	}
+
+	if !IsExpressionEmpty(spec.Attempts) {
+		if diags := gohcl2.DecodeExpression(spec.Attempts, evalCtx, &p.attempts); diags.HasErrors() {
+			return nil, diags
+		}
+
+		if p.attempts < 1 {
+			return nil, fmt.Errorf("attempts must be greater than 0, but was %d", p.attempts)
+		}
+	}
+
+	if !IsExpressionEmpty(spec.Delay) {
+		d, err := decodeDuration(spec.Delay, evalCtx)
+		if err != nil {
+			return nil, xerrors.Errorf("delay: %w", err)
+		}
+
+		p.delay = d
+	}
+
+	p.maxDelay = DefaultRetryMaxDelay
+	if p.delay > p.maxDelay {
+		p.maxDelay = p.delay
+	}
+
+	if !IsExpressionEmpty(spec.MaxDelay) {
+		d, err := decodeDuration(spec.MaxDelay, evalCtx)
+		if err != nil {
+			return nil, xerrors.Errorf("max_delay: %w", err)
+		}
+
+		p.maxDelay = d
+	}
+
+	if !IsExpressionEmpty(spec.Backoff) {
+		if diags := gohcl2.DecodeExpression(spec.Backoff, evalCtx, &p.backoff); diags.HasErrors() {
+			return nil, diags
+		}
+
+		switch p.backoff {
+		case BackoffConstant, BackoffExponential:
+		default:
+			return nil, fmt.Errorf("backoff %q is not supported. It must be either %q or %q", p.backoff, BackoffConstant, BackoffExponential)
+		}
+	}
+
+	p.retryOn = spec.RetryOn
+
+	return p, nil
+}
+
+// delayBefore returns the duration to wait before the attempt, which never exceeds the max delay.
+func (p *retryPolicy) delayBefore(attempt int) time.Duration {
+	d := p.delay
+
+	if p.backoff == BackoffExponential {
+		// The delay is doubled one at a time, so that it never overflows however many attempts there are
+		for i := 2; i < attempt && d < p.maxDelay; i++ {
+			d *= 2
+		}
+	}
+
+	if d > p.maxDelay {
+		return p.maxDelay
+	}
+
+	return d
+}
+
+// shouldRetry tells if the failed attempt should be retried, by evaluating `retry_on` if any.
+func (p *retryPolicy) shouldRetry(evalCtx *hcl2.EvalContext, attempt int, res *Result, err error) (bool, error) {
+	if attempt >= p.attempts {
+		return false, nil
+	}
+
+	if IsExpressionEmpty(p.retryOn) {
+		return true, nil
+	}
+
+	condCtx := cloneEvalContext(evalCtx)
+	condCtx.Variables["retry"] = retryVal(attempt, res, err)
+
+	var retry bool
+
+	if diags := gohcl2.DecodeExpression(p.retryOn, condCtx, &retry); diags.HasErrors() {
+		return false, diags
+	}
+
+	return retry, nil
+}
+
+// retryVal returns the value of the `retry` variable available within the retried block.
+func retryVal(attempt int, res *Result, err error) cty.Value {
+	fields := map[string]cty.Value{
+		"attempt": cty.NumberIntVal(int64(attempt)),
+	}
+
+	if res != nil || err != nil {
+		fields["res"] = res.toCty()
+
+		if err != nil {
+			fields["err"] = cty.StringVal(err.Error())
+		} else {
+			fields["err"] = cty.StringVal("")
+		}
+	}
+
+	return cty.ObjectVal(fields)
+}
+
+// withRetry calls f until it succeeds or the retry policy declared by the `retry` block gives up.
+// onRetry is called before each retry, so that the caller can log the retry.
+func withRetry(
+	ctx context.Context,
+	spec *Retry,
+	evalCtx *hcl2.EvalContext,
+	onRetry func(attempt int, delay time.Duration, lastErr error) error,
+	f func(attempt int) (*Result, error),
+) (*Result, error) {
+	p, err := newRetryPolicy(spec, evalCtx)
+	if err != nil {
+		return nil, xerrors.Errorf("retry: %w", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := f(attempt)
+		if err == nil {
+			return res, nil
+		}
+
+		retry, rErr := p.shouldRetry(evalCtx, attempt, res, err)
+		if rErr != nil {
+			return res, xerrors.Errorf("evaluating retry_on: %w", rErr)
+		}
+
+		if !retry || ctx.Err() != nil {
+			return res, err
+		}
+
+		delay := p.delayBefore(attempt + 1)
+
+		if onRetry != nil {
+			if err := onRetry(attempt+1, delay, err); err != nil {
+				return res, err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return res, err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+func TestRetryDelay(t *testing.T) {
+	type testcase struct {
+		subject string
+		spec    string
+		want    map[int]time.Duration
+	}
+
+	testcases := []testcase{
+		{
+			subject: "constant",
+			spec: `
+attempts = 100
+delay = "10m"
+`,
+			want: map[int]time.Duration{2: 10 * time.Minute, 100: 10 * time.Minute},
+		},
+		{
+			subject: "exponential",
+			spec: `
+attempts = 1000
+delay = "1s"
+backoff = "exponential"
+`,
+			want: map[int]time.Duration{2: time.Second, 3: 2 * time.Second, 4: 4 * time.Second, 100: DefaultRetryMaxDelay, 1000: DefaultRetryMaxDelay},
+		},
+		{
+			subject: "exponential with max_delay",
+			spec: `
+attempts = 1000
+delay = "1s"
+backoff = "exponential"
+max_delay = "3s"
+`,
+			want: map[int]time.Duration{2: time.Second, 3: 2 * time.Second, 4: 3 * time.Second, 1000: 3 * time.Second},
+		},
+	}
+
+	for i := range testcases {
+		tc := testcases[i]
+
+		t.Run(tc.subject, func(t *testing.T) {
+			f, diags := hclsyntax.ParseConfig([]byte(tc.spec), "retry.variant", hcl.Pos{Line: 1, Column: 1})
+			if diags.HasErrors() {
+				t.Fatal(diags)
+			}
+
+			var spec Retry
+
+			if diags := gohcl.DecodeBody(f.Body, nil, &spec); diags.HasErrors() {
+				t.Fatal(diags)
+			}
+
+			p, err := newRetryPolicy(&spec, &hcl.EvalContext{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			prev := time.Duration(0)
+
+			for attempt := 2; attempt <= p.attempts; attempt++ {
+				d := p.delayBefore(attempt)
+
+				if d < prev {
+					t.Fatalf("delay before attempt %d must not be shorter than the previous one: %v < %v", attempt, d, prev)
+				}
+
+				if want, ok := tc.want[attempt]; ok && d != want {
+					t.Errorf("unexpected delay before attempt %d: want %v, got %v", attempt, want, d)
+				}
+
+				prev = d
+			}
+		})
+	}
+}
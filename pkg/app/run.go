@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"strings"
 	"sync"
 
@@ -8,7 +9,7 @@ import (
 	"github.com/hashicorp/hcl/v2/gohcl"
 )
 
-func (app *App) runJobInBody(l *EventLogger, jobCtx *JobContext, body hcl.Body, streamOutput bool) (*Result, bool, error) {
+func (app *App) runJobInBody(ctx context.Context, l *EventLogger, jobCtx *JobContext, body hcl.Body, streamOutput bool) (*Result, bool, error) {
 	var runs []eitherJobRun
 
 	var lazyStaticRun LazyStaticRun
@@ -61,7 +62,7 @@ func (app *App) runJobInBody(l *EventLogger, jobCtx *JobContext, body hcl.Body,
 	var results []*Result
 
 	for _, r := range runs {
-		res, err := app.runJobAndUpdateContext(l, jobCtx, r, new(sync.Mutex), streamOutput)
+		res, err := app.runJobAndUpdateContext(ctx, l, jobCtx, r, new(sync.Mutex), streamOutput)
 		if err != nil {
 			return res, true, err
 		}
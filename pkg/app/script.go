@@ -0,0 +1,75 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+)
+
+// DefaultInterpreter is the interpreter of the `script` of the `exec` that has no `interpreter`.
+var DefaultInterpreter = []string{"bash", "-euo", "pipefail"}
+
+// writeScript writes the script to a temporary file to be run by the interpreter.
+// The returned func removes the file.
+func writeScript(script string) (string, func(), error) {
+	f, err := ioutil.TempFile("", "variant-script-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	remove := func() {
+		os.Remove(f.Name())
+	}
+
+	if _, err := f.WriteString(script); err != nil {
+		f.Close()
+		remove()
+
+		return "", nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		remove()
+
+		return "", nil, err
+	}
+
+	return f.Name(), remove, nil
+}
+
+// withScriptPath returns the args of the interpreter, with the path to the script file inserted at cmd.ScriptPos.
+func (cmd Command) withScriptPath(path string) []string {
+	args := append([]string{}, cmd.Args[:cmd.ScriptPos]...)
+	args = append(args, path)
+
+	return append(args, cmd.Args[cmd.ScriptPos:]...)
+}
+
+// scriptLineRewriter returns a func that rewrites references to lines of the script file in the output of the interpreter,
+// like "/tmp/variant-script-123: line 3" produced by bash, to the corresponding location in the .variant source.
+func (app *App) scriptLineRewriter(path string, rng hcl2.Range) func(string) string {
+	firstLine := rng.Start.Line
+
+	// The script in a heredoc starts at the line next to the one containing `<<EOS`
+	if f, ok := app.Files[rng.Filename]; ok && rng.Start.Byte < len(f.Bytes) && bytes.HasPrefix(f.Bytes[rng.Start.Byte:], []byte("<<")) {
+		firstLine++
+	}
+
+	ref := regexp.MustCompile(regexp.QuoteMeta(path) + `(?:: line |:)(\d+)`)
+
+	return func(s string) string {
+		return ref.ReplaceAllStringFunc(s, func(m string) string {
+			n, err := strconv.Atoi(ref.FindStringSubmatch(m)[1])
+			if err != nil {
+				return m
+			}
+
+			return fmt.Sprintf("%s:%d", rng.Filename, firstLine+n-1)
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// SecretMask is what secret values are replaced with in outputs, error messages and logs.
+const SecretMask = "***"
+
+// addSecrets registers all the strings contained in the value as secrets to be masked by sanitize.
+func (app *App) addSecrets(v cty.Value) {
+	var values []string
+
+	collectSecretStrings(v, &values)
+
+	app.addSecretStrings(values...)
+}
+
+// addSensitiveArgs registers the values of the sensitive options of the job found in args as secrets,
+// so that they are masked even in the event of the run of the job, which is logged before the job starts.
+func (app *App) addSensitiveArgs(job string, args map[string]interface{}) {
+	var specs []OptionSpec
+
+	if app.Config != nil {
+		specs = append(specs, app.Config.Options...)
+	}
+
+	if j, ok := app.JobByName[job]; ok && job != "" {
+		specs = append(specs, j.Options...)
+	}
+
+	var values []string
+
+	for _, o := range specs {
+		if o.Sensitive == nil || !*o.Sensitive {
+			continue
+		}
+
+		switch v := args[o.Name].(type) {
+		case string:
+			if v != "" {
+				values = append(values, v)
+			}
+		case []string:
+			for _, s := range v {
+				if s != "" {
+					values = append(values, s)
+				}
+			}
+		}
+	}
+
+	app.addSecretStrings(values...)
+}
+
+func (app *App) addSecretStrings(values ...string) {
+	if len(values) == 0 {
+		return
+	}
+
+	app.secretsMutex.Lock()
+	defer app.secretsMutex.Unlock()
+
+	if app.secretValues == nil {
+		app.secretValues = map[string]struct{}{}
+	}
+
+	for _, s := range values {
+		app.secretValues[s] = struct{}{}
+	}
+
+	// Longer secrets are replaced first, so that a secret containing another one is fully masked
+	sorted := make([]string, 0, len(app.secretValues))
+
+	for s := range app.secretValues {
+		sorted = append(sorted, s)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+
+		return sorted[i] < sorted[j]
+	})
+
+	oldnew := make([]string, 0, 2*len(sorted))
+
+	for _, s := range sorted {
+		oldnew = append(oldnew, s, SecretMask)
+	}
+
+	app.secretReplacer = strings.NewReplacer(oldnew...)
+}
+
+func collectSecretStrings(v cty.Value, values *[]string) {
+	if v.IsNull() || !v.IsKnown() {
+		return
+	}
+
+	tpe := v.Type()
+
+	switch {
+	case tpe == cty.String:
+		if s := v.AsString(); s != "" {
+			*values = append(*values, s)
+		}
+	case tpe.IsObjectType() || tpe.IsMapType() || tpe.IsListType() || tpe.IsSetType() || tpe.IsTupleType():
+		for it := v.ElementIterator(); it.Next(); {
+			_, e := it.Element()
+
+			collectSecretStrings(e, values)
+		}
+	}
+}
+
+// sanitize masks the secrets contained in the string.
+func (app *App) sanitize(str string) string {
+	app.secretsMutex.Lock()
+	r := app.secretReplacer
+	app.secretsMutex.Unlock()
+
+	if r == nil {
+		return str
+	}
+
+	return r.Replace(str)
+}
+
+// sanitizingWriter masks secrets in what is written to the underlying writer.
+// It buffers the written bytes until the end of the line, so that a secret split across writes is still masked.
+// Flush must be called after the last write, to write the rest that is not terminated by a newline.
+type sanitizingWriter struct {
+	app *App
+	w   io.Writer
+
+	buf []byte
+}
+
+func (w *sanitizingWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	// Carriage returns are taken as line endings too, so that progress bars are written as they are updated
+	i := bytes.LastIndexAny(w.buf, "\r\n")
+	if i < 0 {
+		return len(p), nil
+	}
+
+	lines := w.buf[:i+1]
+
+	if _, err := w.w.Write([]byte(w.app.sanitize(string(lines)))); err != nil {
+		return 0, err
+	}
+
+	w.buf = append(w.buf[:0], w.buf[i+1:]...)
+
+	return len(p), nil
+}
+
+// Flush writes the buffered bytes not terminated by a newline.
+func (w *sanitizingWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	_, err := w.w.Write([]byte(w.app.sanitize(string(w.buf))))
+
+	w.buf = w.buf[:0]
+
+	return err
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSanitizingWriter(t *testing.T) {
+	app := &App{}
+	app.addSecretStrings("s3cr3t")
+
+	var buf bytes.Buffer
+
+	w := &sanitizingWriter{app: app, w: &buf}
+
+	for _, chunk := range []string{"token=s3c", "r3t\nnext=s3", "cr3t"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := buf.String(), "token=***\n"; got != want {
+		t.Errorf("unexpected output before flush: want %q, got %q", want, got)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), "token=***\nnext=***"; got != want {
+		t.Errorf("unexpected output: want %q, got %q", want, got)
+	}
+}
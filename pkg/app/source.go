@@ -12,7 +12,7 @@ import (
 	"github.com/mumoshu/variant2/pkg/source"
 )
 
-func (app *App) checkoutSources(_ *EventLogger, jobCtx *JobContext, sources []Source, concurrency int) error {
+func (app *App) checkoutSources(ctx context.Context, _ *EventLogger, jobCtx *JobContext, sources []Source, concurrency int) error {
 	if len(sources) == 0 {
 		return nil
 	}
@@ -45,7 +45,7 @@ func (app *App) checkoutSources(_ *EventLogger, jobCtx *JobContext, sources []So
 
 	resultCh := make(chan result)
 
-	sourceWorkers, ctx := errgroup.WithContext(context.Background())
+	sourceWorkers, ctx := errgroup.WithContext(ctx)
 
 	for i := 0; i < concurrency; i++ {
 		sourceWorkers.Go(func() error {
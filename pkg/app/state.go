@@ -0,0 +1,224 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// DefaultStateDir returns the directory to store run-state files, which is $VARIANT_STATE_DIR if set,
+// or `variant/runs` under the user cache directory otherwise.
+func DefaultStateDir() string {
+	if d := os.Getenv("VARIANT_STATE_DIR"); d != "" {
+		return d
+	}
+
+	d, err := os.UserCacheDir()
+	if err != nil {
+		d = os.TempDir()
+	}
+
+	return filepath.Join(d, "variant", "runs")
+}
+
+// runState is the checkpoint of the run, which contains the results of the steps of the job run by the command
+// that have completed successfully.
+// It is written to the run-state file on every step completion, so that a failed run can be resumed.
+type runState struct {
+	ID  string `json:"id"`
+	Job string `json:"job"`
+	// ArgsHash is the hash of the resolved params and options of the job, so that the run is resumed only with the same ones
+	ArgsHash string                  `json:"args_hash"`
+	Steps    map[string]storedResult `json:"steps"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// storedResult is the Result persisted to files like run-state files and result caches.
+// Its stdout and stderr are stored as they are including secrets, so that the restored result is the same as the original,
+// and the files are readable only by the user.
+type storedResult struct {
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitStatus int    `json:"exitstatus"`
+	Skipped    bool   `json:"skipped,omitempty"`
+
+	Outputs storedOutputs `json:"outputs,omitempty"`
+}
+
+func newStoredResult(res *Result) storedResult {
+	return storedResult{
+		Stdout:     res.Stdout,
+		Stderr:     res.Stderr,
+		ExitStatus: res.ExitStatus,
+		Skipped:    res.Skipped,
+		Outputs:    res.Outputs,
+	}
+}
+
+func (r storedResult) toResult() *Result {
+	return &Result{
+		Stdout:     r.Stdout,
+		Stderr:     r.Stderr,
+		ExitStatus: r.ExitStatus,
+		Skipped:    r.Skipped,
+		Outputs:    r.Outputs,
+	}
+}
+
+func newRunID() (string, error) {
+	b := make([]byte, 4)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102150405"), hex.EncodeToString(b)), nil
+}
+
+func runStatePath(dir, id string) string {
+	return filepath.Join(dir, id+".json")
+}
+
+func newRunState(dir, job string) (*runState, error) {
+	id, err := newRunID()
+	if err != nil {
+		return nil, xerrors.Errorf("generating run id: %w", err)
+	}
+
+	return &runState{
+		ID:    id,
+		Job:   job,
+		Steps: map[string]storedResult{},
+		path:  runStatePath(dir, id),
+	}, nil
+}
+
+// loadRunState loads the run-state file of the run to be resumed.
+func loadRunState(dir, id, job string) (*runState, error) {
+	path := runStatePath(dir, id)
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, xerrors.Errorf("loading state of run %q: %w", id, err)
+	}
+
+	s := &runState{}
+
+	if err := json.Unmarshal(bs, s); err != nil {
+		return nil, xerrors.Errorf("parsing %s: %w", path, err)
+	}
+
+	if s.Job != job {
+		return nil, fmt.Errorf("run %q can not be resumed as job %q, as it was a run of job %q", id, job, s.Job)
+	}
+
+	if s.Steps == nil {
+		s.Steps = map[string]storedResult{}
+	}
+
+	s.path = path
+
+	return s, nil
+}
+
+// bindArgs records the hash of the resolved args of the job of the run, or verifies that the run being resumed
+// had the same args.
+func (s *runState) bindArgs(hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ArgsHash != "" && s.ArgsHash != hash {
+		return fmt.Errorf("run %q can not be resumed, as it was a run of job %q with different parameters or options", s.ID, s.Job)
+	}
+
+	s.ArgsHash = hash
+
+	return nil
+}
+
+// completed returns the result of the step if it has been completed in the previous run.
+func (s *runState) completed(id string) (*Result, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.Steps[id]
+	if !ok {
+		return nil, false
+	}
+
+	return r.toResult(), true
+}
+
+// checkpoint records the result of the completed step and writes the run-state file.
+func (s *runState) checkpoint(id string, res *Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Steps[id] = newStoredResult(res)
+
+	bs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that the state file is never left half-written
+	tmp := s.path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, bs, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// exists tells if the run-state file has been written, that is, if any step has completed.
+func (s *runState) exists() bool {
+	_, err := os.Stat(s.path)
+
+	return err == nil
+}
+
+func (s *runState) remove() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// openRunState starts a new run-state, or loads the one of the run to be resumed.
+func (app *App) openRunState(job string) (*runState, error) {
+	if app.Resume != "" {
+		return loadRunState(app.StateDir, app.Resume, job)
+	}
+
+	return newRunState(app.StateDir, job)
+}
+
+// closeRunState removes the run-state file once the run succeeded.
+// Otherwise it is kept and how to resume the run is printed.
+func (app *App) closeRunState(s *runState, runErr error) error {
+	if runErr == nil {
+		return s.remove()
+	}
+
+	if s.exists() && app.Stderr != nil {
+		fmt.Fprintf(app.Stderr, "The run can be resumed from the failed step with `--resume %s`\n", s.ID)
+	}
+
+	return nil
+}
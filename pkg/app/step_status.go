@@ -0,0 +1,88 @@
+package app
+
+import (
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	// OnFailureFailFast stops starting steps as soon as any step fails. This is the default.
+	OnFailureFailFast = "fail_fast"
+	// OnFailureContinue keeps running steps that do not depend on failed steps.
+	OnFailureContinue = "continue"
+	// OnFailureFinishWave lets the steps in the same wave as the failed step to finish, but does not start the next wave.
+	OnFailureFinishWave = "finish_wave"
+)
+
+const (
+	StepStatusSucceeded = "succeeded"
+	StepStatusFailed    = "failed"
+	StepStatusSkipped   = "skipped"
+	// StepStatusCancelled is the status of a step that has not run due to a failure or a cancellation.
+	StepStatusCancelled = "cancelled"
+)
+
+// StepStatus is a row of the status table of the steps of a job.
+type StepStatus struct {
+	// Name is the name of the step. It is suffixed with the key of the instance like `deploy["prd/eu"]` for
+	// a for_each or matrix step.
+	Name       string
+	Status     string
+	ExitStatus int
+	Err        error
+}
+
+func newStepStatus(name string, res *Result, err error) StepStatus {
+	s := StepStatus{Name: name, Err: err}
+
+	if res != nil {
+		s.ExitStatus = res.ExitStatus
+	}
+
+	switch {
+	case err != nil:
+		s.Status = StepStatusFailed
+
+		if res == nil {
+			s.ExitStatus = 1
+		}
+	case res == nil || res.Cancelled:
+		s.Status = StepStatusCancelled
+	case res.Skipped:
+		s.Status = StepStatusSkipped
+	default:
+		s.Status = StepStatusSucceeded
+	}
+
+	return s
+}
+
+var stepStatusType = cty.Object(map[string]cty.Type{
+	"status":     cty.String,
+	"exitstatus": cty.Number,
+	"err":        cty.String,
+})
+
+func stepStatusesToCty(statuses []StepStatus) cty.Value {
+	if len(statuses) == 0 {
+		// Cuz calling cty.MapVal on an empty map panics by its nature
+		return cty.MapValEmpty(stepStatusType)
+	}
+
+	m := map[string]cty.Value{}
+
+	for _, s := range statuses {
+		var err string
+
+		if s.Err != nil {
+			err = s.Err.Error()
+		}
+
+		m[s.Name] = cty.ObjectVal(map[string]cty.Value{
+			"status":     cty.StringVal(s.Status),
+			"exitstatus": cty.NumberIntVal(int64(s.ExitStatus)),
+			"err":        cty.StringVal(err),
+		})
+	}
+
+	return cty.MapVal(m)
+}
@@ -13,6 +13,10 @@ type PendingInput struct {
 	Name        string
 	Description *string
 	Type        cty.Type
+
+	// Approval is set to true for the input named ApprovalInputName, which asks for the approval of the job.
+	// Description is the message and the plan of the job, and the input is a bool that approves the job when true
+	Approval bool
 }
 
 func MakeQuestions(pendingOptions []PendingInput) ([]*survey.Question, map[string]survey.Transformer, error) {
@@ -39,13 +43,21 @@ func MakeQuestions(pendingOptions []PendingInput) ([]*survey.Question, map[strin
 
 		var prompt survey.Prompt
 
-		switch op.Type {
-		case cty.String:
+		switch {
+		case op.Approval:
+			prompt = &survey.Input{
+				Message: fmt.Sprintf("%s\nEnter \"yes\" to approve:", description),
+			}
+
+			transform = func(ans interface{}) (newAns interface{}) {
+				return ans.(string) == "yes"
+			}
+		case op.Type == cty.String:
 			prompt = &survey.Input{
 				Message: msg,
 				Help:    description,
 			}
-		case cty.Number:
+		case op.Type == cty.Number:
 			prompt = &survey.Input{
 				Message: msg,
 				Help:    description,
@@ -69,13 +81,13 @@ func MakeQuestions(pendingOptions []PendingInput) ([]*survey.Question, map[strin
 
 				return nil
 			}
-		case cty.Bool:
+		case op.Type == cty.Bool:
 			prompt = &survey.Confirm{
 				Message: msg,
 				Help:    description,
 				Default: false,
 			}
-		case cty.List(cty.String):
+		case op.Type.Equals(cty.List(cty.String)):
 			prompt = &survey.Multiline{
 				Message: msg,
 				Help:    description,
@@ -96,7 +108,7 @@ func MakeQuestions(pendingOptions []PendingInput) ([]*survey.Question, map[strin
 
 				return nil
 			}
-		case cty.List(cty.Number):
+		case op.Type.Equals(cty.List(cty.Number)):
 			prompt = &survey.Multiline{
 				Message: msg,
 				Help:    description,
@@ -136,7 +148,12 @@ func MakeQuestions(pendingOptions []PendingInput) ([]*survey.Question, map[strin
 			return nil, nil, fmt.Errorf("option %q: unexpected type %q", op.Name, op.Type.FriendlyName())
 		}
 
-		validators := []survey.Validator{survey.Required}
+		var validators []survey.Validator
+
+		// Anything other than "yes" denies the approval
+		if !op.Approval {
+			validators = append(validators, survey.Required)
+		}
 
 		if validate != nil {
 			validators = append(validators, validate)
@@ -2,6 +2,7 @@ package app
 
 import (
 	"io"
+	"strings"
 	"sync"
 
 	"github.com/hashicorp/hcl/v2"
@@ -35,6 +36,26 @@ type Step struct {
 	Run StaticRun `hcl:"run,block"`
 
 	Needs *[]string `hcl:"need,attr"`
+
+	// ForEach expands the step into one step per element of the map or the list of strings.
+	// Each element is available as `each.key` and `each.value`, and the results are collected as a map under `step.<name>`.
+	ForEach hcl.Expression `hcl:"for_each,attr"`
+
+	// Matrix expands the step into one step per combination of the values of the matrix dimensions.
+	Matrix *Matrix `hcl:"matrix,block"`
+
+	// ContinueOnError makes the failure of the step not to fail the job. Steps depending on it still run.
+	ContinueOnError *bool `hcl:"continue_on_error,attr"`
+
+	// Condition is evaluated against opt, param, var, conf and the results of the previous steps.
+	// The step is skipped when it is false.
+	Condition hcl.Expression `hcl:"condition,attr"`
+
+	Retry *Retry `hcl:"retry,block"`
+
+	// Lock is the name of the lock held while the step runs. See JobSpec.Lock
+	Lock        hcl.Expression `hcl:"lock,attr"`
+	LockTimeout hcl.Expression `hcl:"lock_timeout,attr"`
 }
 
 type Exec struct {
@@ -44,7 +65,73 @@ type Exec struct {
 	Env  hcl.Expression `hcl:"env,attr"`
 	Dir  hcl.Expression `hcl:"dir,attr"`
 
+	// EnvInherit is either a bool or a list of names of the environment variables of variant inherited by the command.
+	// Defaults to true, which inherits all of them
+	EnvInherit hcl.Expression `hcl:"env_inherit,attr"`
+
+	// Script is the script run by the interpreter instead of the command. The args are given to the script
+	Script hcl.Expression `hcl:"script,attr"`
+	// Interpreter is the command and args like `["python3"]` to run the script. Defaults to DefaultInterpreter
+	Interpreter hcl.Expression `hcl:"interpreter,attr"`
+
 	Interactive *bool `hcl:"interactive,attr"`
+
+	// Stdin is the string fed to the command, like the stdout of the previous step
+	Stdin hcl.Expression `hcl:"stdin,attr"`
+	// StdinFile is the path to the file fed to the command. It is relative to the dir
+	StdinFile hcl.Expression `hcl:"stdin_file,attr"`
+
+	// Timeout is the duration like "5m" after which the command is terminated
+	Timeout hcl.Expression `hcl:"timeout,attr"`
+
+	// Creates is the path or the glob pattern of the file created by the command. The exec is skipped when it exists
+	Creates hcl.Expression `hcl:"creates,attr"`
+	// Removes is the path or the glob pattern of the file removed by the command. The exec is skipped when it does not exist
+	Removes hcl.Expression `hcl:"removes,attr"`
+	// Unless is the shell script, the command and args, or the job run like `{ job = "name", args = {} }`.
+	// The exec is skipped when it succeeds
+	Unless hcl.Expression `hcl:"unless,attr"`
+
+	Retry *Retry `hcl:"retry,block"`
+}
+
+// Cache makes the successful result of the job reused by later runs of the job with the same args and keys.
+type Cache struct {
+	// Key is an optional list of values that the result depends on, in addition to the job name and the args
+	Key hcl.Expression `hcl:"key,attr"`
+	// TTL is the duration like "1h" the result is reused for. The result never expires when omitted
+	TTL hcl.Expression `hcl:"ttl,attr"`
+}
+
+// Finally contains runs that always run after the job, regardless of whether it succeeded, failed or was cancelled.
+type Finally struct {
+	Run []StaticRun `hcl:"run,block"`
+}
+
+// Matrix declares dimensions as lists of values, like `env = ["dev", "prd"]`, whose cartesian product
+// is run by the enclosing step.
+type Matrix struct {
+	// Exclude is a list of objects. Combinations matching all the values of any of the objects are removed
+	Exclude hcl.Expression `hcl:"exclude,attr"`
+	// Include is a list of objects, each of which adds a combination
+	Include hcl.Expression `hcl:"include,attr"`
+
+	Dimensions hcl.Attributes `hcl:",remain"`
+}
+
+// Retry declares how a failed step or exec is retried.
+// It is not allowed in `run` blocks, as it would conflict with the job argument named `retry`.
+type Retry struct {
+	// Attempts is the maximum number of attempts including the first one
+	Attempts hcl.Expression `hcl:"attempts,attr"`
+	// Delay is the duration like "2s" to wait before each retry
+	Delay hcl.Expression `hcl:"delay,attr"`
+	// Backoff is either "constant" or "exponential". Defaults to "constant"
+	Backoff hcl.Expression `hcl:"backoff,attr"`
+	// MaxDelay is the duration like "1m" the exponentially growing delay is capped at. Defaults to DefaultRetryMaxDelay
+	MaxDelay hcl.Expression `hcl:"max_delay,attr"`
+	// RetryOn is the condition to retry the failed attempt, evaluated against `retry.attempt`, `retry.res` and `retry.err`
+	RetryOn hcl.Expression `hcl:"retry_on,attr"`
 }
 
 type DependsOn struct {
@@ -104,6 +191,9 @@ type OptionSpec struct {
 	Default     hcl.Expression `hcl:"default,attr"`
 	Description *string        `hcl:"description,attr"`
 	Short       *string        `hcl:"short,attr"`
+
+	// Sensitive masks the value of the option in outputs, error messages and logs when set to true
+	Sensitive *bool `hcl:"sensitive,attr"`
 }
 
 type Variable struct {
@@ -113,6 +203,22 @@ type Variable struct {
 	Value hcl.Expression `hcl:"value,attr"`
 }
 
+// Approval is the `approval` block of the job, which requires the job to be approved before it runs
+type Approval struct {
+	// Message is shown to the approver along with the plan of the job
+	Message hcl.Expression `hcl:"message,attr"`
+	// Required is whether the approval is required, like `opt.env == "prd"`. Defaults to true
+	Required hcl.Expression `hcl:"required,attr"`
+}
+
+// OutputSpec is the `output` block of the job, whose value is evaluated after the job completes successfully
+type OutputSpec struct {
+	Name string `hcl:"name,label"`
+
+	Type  hcl.Expression `hcl:"type,attr"`
+	Value hcl.Expression `hcl:"value,attr"`
+}
+
 type JobSpec struct {
 	// Type string `hcl:"type,label"`
 	Name string `hcl:"name,label"`
@@ -130,10 +236,46 @@ type JobSpec struct {
 
 	Concurrency hcl.Expression `hcl:"concurrency,attr"`
 
+	// Timeout is the duration like "5m" after which the job and all the commands run by it are terminated
+	Timeout hcl.Expression `hcl:"timeout,attr"`
+
+	// OnFailure is either "fail_fast"(default), "continue" or "finish_wave", which controls how steps are run after a step fails
+	OnFailure hcl.Expression `hcl:"on_failure,attr"`
+
+	// Env is the environment variables given to every exec run by the job and the jobs called by it
+	Env hcl.Expression `hcl:"env,attr"`
+
+	// ExitCodeOnFailure is the exit code of the variant process when the job failed.
+	// Defaults to the exit status of the failed command
+	ExitCodeOnFailure *int `hcl:"exit_code_on_failure,attr"`
+
+	// Lock is the name of the lock held while the job runs. Jobs and steps sharing the lock never run concurrently,
+	// even in different processes
+	Lock hcl.Expression `hcl:"lock,attr"`
+	// LockTimeout is the maximum duration like "10m" to wait for the lock. Defaults to waiting forever
+	LockTimeout hcl.Expression `hcl:"lock_timeout,attr"`
+
+	// Executor is the name of the executor registered to App.Executors, which runs every exec of the job and
+	// the jobs called by it
+	Executor hcl.Expression `hcl:"executor,attr"`
+
 	SourceLocator hcl.Expression `hcl:"__source_locator,attr"`
 
-	Deps    []DependsOn    `hcl:"depends_on,block"`
+	Deps    []DependsOn `hcl:"depends_on,block"`
+	Finally []Finally   `hcl:"finally,block"`
+	Cache   *Cache      `hcl:"cache,block"`
+
+	// Inputs and Outputs are lists of files. The job is skipped when every output is newer than every input,
+	// or the contents of the inputs are unchanged since the last successful run
+	Inputs  hcl.Expression `hcl:"inputs,attr"`
+	Outputs hcl.Expression `hcl:"outputs,attr"`
 	Exec    *Exec          `hcl:"exec,block"`
+
+	Approval *Approval `hcl:"approval,block"`
+
+	// OutputValues are the typed values returned by the job, available as `step.<name>.outputs` to the caller
+	OutputValues []OutputSpec `hcl:"output,block"`
+
 	Assert  []Assert       `hcl:"assert,block"`
 	Fail    hcl.Expression `hcl:"fail,attr"`
 	Import  *string        `hcl:"import,attr"`
@@ -184,8 +326,12 @@ type Assert struct {
 }
 
 type HCL2Config struct {
-	Jobs    []JobSpec `hcl:"job,block"`
-	Tests   []Test    `hcl:"test,block"`
+	Jobs  []JobSpec `hcl:"job,block"`
+	Tests []Test    `hcl:"test,block"`
+
+	// MaxParallel is the maximum number of commands run at the same time across the whole run. See App.MaxParallel
+	MaxParallel *int `hcl:"max_parallel,attr"`
+
 	JobSpec `hcl:",remain"`
 }
 
@@ -236,10 +382,52 @@ type App struct {
 
 	Trace string
 
+	// MaxParallel is the maximum number of commands run at the same time across the whole run,
+	// including the ones run by the steps of nested jobs and `depends_on` items.
+	// Zero means the `max_parallel` attribute, or no limit when it is not set either
+	MaxParallel int
+
+	// AssumeYes approves every job that requires approval, without asking for it
+	AssumeYes bool
+
+	// DryRun makes Run print the jobs, the steps grouped by waves and the commands that would be run,
+	// without actually running any command
+	DryRun bool
+
+	// StateDir is the directory to store run-state files. When set, the results of the steps of the job are checkpointed
+	// to the run-state file, so that a failed run can be resumed
+	StateDir string
+
+	// Resume is the ID of the failed run to be resumed. Steps that have completed in the run are not run again
+	Resume string
+
+	// CacheDir is the directory containing the result cache. Defaults to DefaultCacheDir
+	CacheDir string
+
+	// Executor runs the commands of `exec` blocks. Defaults to the one running them in local processes
+	Executor Executor
+	// Executors are the executors selected per job by the `executor` attribute
+	Executors map[string]Executor
+
+	// OutputMode is either "raw"(default), "prefixed" or "grouped", which controls how the output of steps is written
+	OutputMode string
+
 	sourceClient *source.Client
 
 	initMu sync.Mutex
 
+	// locks are the named locks held by the jobs and the steps run by this process
+	locks      map[string]chan struct{}
+	locksMutex sync.Mutex
+
+	// secretValues are the values of secrets and sensitive options masked by sanitize
+	secretValues   map[string]struct{}
+	secretReplacer *strings.Replacer
+	secretsMutex   sync.Mutex
+
+	// outputMutex serializes the writes of the output of concurrent steps
+	outputMutex sync.Mutex
+
 	Funcs map[string]function.Function
 
 	JobLocalFuncs map[string]map[string]function.Function
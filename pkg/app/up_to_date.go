@@ -0,0 +1,243 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	hcl2 "github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"golang.org/x/xerrors"
+)
+
+// upToDateCheck decides if the job can be skipped because its outputs are up to date with its inputs, like Make does.
+type upToDateCheck struct {
+	inputs  []string
+	outputs []string
+
+	// hasOutputValues is true when the job has `output` blocks, whose values are restored from the last successful run
+	// when the job is skipped
+	hasOutputValues bool
+
+	// statePath is the file storing the hashes of the inputs and the outputs of the last successful run
+	statePath string
+}
+
+type upToDateState struct {
+	Inputs  map[string]string `json:"inputs"`
+	Outputs storedOutputs     `json:"outputs,omitempty"`
+}
+
+func (app *App) newUpToDateCheck(j JobSpec, evalCtx *hcl2.EvalContext) (*upToDateCheck, error) {
+	inputs, err := decodeStringList(j.Inputs, evalCtx)
+	if err != nil {
+		return nil, xerrors.Errorf("inputs: %w", err)
+	}
+
+	outputs, err := decodeStringList(j.Outputs, evalCtx)
+	if err != nil {
+		return nil, xerrors.Errorf("outputs: %w", err)
+	}
+
+	key, err := resultCacheKey(j.Name, nil, evalCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := app.CacheDir
+	if dir == "" {
+		dir = DefaultCacheDir
+	}
+
+	return &upToDateCheck{
+		inputs:          inputs,
+		outputs:         outputs,
+		hasOutputValues: len(j.OutputValues) > 0,
+		statePath:       filepath.Join(dir, "up-to-date", key+".json"),
+	}, nil
+}
+
+// upToDate tells if every output exists and is newer than every input, or the contents of the inputs are
+// unchanged since the last successful run. It also returns the values of the `output` blocks of the last successful run.
+// The job having `output` blocks is never up to date without them, so that they are evaluated by running the job.
+func (c *upToDateCheck) upToDate() (bool, map[string]cty.Value, error) {
+	prev, err := c.load()
+	if err != nil {
+		return false, nil, err
+	}
+
+	if c.hasOutputValues && (prev == nil || prev.Outputs == nil) {
+		return false, nil, nil
+	}
+
+	var outputValues map[string]cty.Value
+
+	if prev != nil {
+		outputValues = prev.Outputs
+	}
+
+	var oldestOutput int64
+
+	for i, o := range c.outputs {
+		info, err := os.Stat(o)
+		if os.IsNotExist(err) {
+			return false, nil, nil
+		} else if err != nil {
+			return false, nil, err
+		}
+
+		if t := info.ModTime().UnixNano(); i == 0 || t < oldestOutput {
+			oldestOutput = t
+		}
+	}
+
+	newer := len(c.outputs) > 0
+
+	for _, in := range c.inputs {
+		info, err := os.Stat(in)
+		if err != nil {
+			return false, nil, xerrors.Errorf("input: %w", err)
+		}
+
+		if info.ModTime().UnixNano() >= oldestOutput {
+			newer = false
+
+			break
+		}
+	}
+
+	if newer {
+		return true, outputValues, nil
+	}
+
+	if prev == nil {
+		return false, nil, nil
+	}
+
+	cur, err := hashFiles(c.inputs)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if len(cur) != len(prev.Inputs) {
+		return false, nil, nil
+	}
+
+	for path, h := range cur {
+		if prev.Inputs[path] != h {
+			return false, nil, nil
+		}
+	}
+
+	return true, outputValues, nil
+}
+
+// load reads the state of the last successful run, or returns nil when there is none.
+func (c *upToDateCheck) load() (*upToDateState, error) {
+	bs, err := ioutil.ReadFile(c.statePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var s upToDateState
+
+	if err := json.Unmarshal(bs, &s); err != nil {
+		// A broken state file is treated as missing, so that it is overwritten by the next run
+		return nil, nil
+	}
+
+	return &s, nil
+}
+
+// save stores the hashes of the inputs and the values of the `output` blocks, so that the next run can be skipped
+// when the inputs are unchanged.
+func (c *upToDateCheck) save(outputValues map[string]cty.Value) error {
+	hashes, err := hashFiles(c.inputs)
+	if err != nil {
+		return err
+	}
+
+	bs, err := json.Marshal(upToDateState{Inputs: hashes, Outputs: outputValues})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.statePath), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.statePath, bs, 0600)
+}
+
+func hashFiles(paths []string) (map[string]string, error) {
+	hashes := map[string]string{}
+
+	for _, p := range paths {
+		h, err := hashFile(p)
+		if err != nil {
+			return nil, xerrors.Errorf("hashing %s: %w", p, err)
+		}
+
+		hashes[p] = h
+	}
+
+	return hashes, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// decodeStringList decodes a list, a set or a tuple of strings like the one returned by `fileset`, into sorted strings.
+func decodeStringList(expr hcl2.Expression, evalCtx *hcl2.EvalContext) ([]string, error) {
+	if IsExpressionEmpty(expr) {
+		return nil, nil
+	}
+
+	v, diags := expr.Value(evalCtx)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	if v.IsNull() {
+		return nil, nil
+	}
+
+	l, err := convert.Convert(v, cty.List(cty.String))
+	if err != nil {
+		return nil, fmt.Errorf("must be a list of strings, but was %s", v.Type().FriendlyName())
+	}
+
+	var strs []string
+
+	for it := l.ElementIterator(); it.Next(); {
+		_, ev := it.Element()
+
+		strs = append(strs, ev.AsString())
+	}
+
+	sort.Strings(strs)
+
+	return strs, nil
+}
@@ -1,11 +1,13 @@
 package slack
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/nlopes/slack"
 	"github.com/rs/xid"
@@ -14,10 +16,15 @@ import (
 
 const (
 	// action is used for slack attament action.
-	actionSelect = "select"
-	actionCancel = "cancel"
+	actionSelect  = "select"
+	actionCancel  = "cancel"
+	actionApprove = "approve"
+	actionDeny    = "deny"
 )
 
+// InteractionTimeout is the maximum duration to wait for the user to interact with the posted message or dialog.
+const InteractionTimeout = 30 * time.Minute
+
 type InteractionCallbackHandler func(slack.InteractionCallback) (interface{}, error)
 
 type Connection struct {
@@ -58,6 +65,13 @@ type Selection struct {
 	Options []string
 }
 
+type Approval struct {
+	// Channel is the ID of the channel to post the approval request. Defaults to Connection.Channel
+	Channel string
+
+	Text string
+}
+
 func New(triggerCmd, botUserOAuthAccessToken string, verificationToken string, callback func(*Connection, string, slack.SlashCommand) string) *Connection {
 	return &Connection{
 		triggerCmd:                     triggerCmd,
@@ -143,28 +157,106 @@ func (conn *Connection) Select(sel Selection) (*string, error) {
 		Markdown: true,
 	}
 
-	respChannel, respTS, err := conn.Client.PostMessage(conn.Channel, slack.MsgOptionPostMessageParameters(params), slack.MsgOptionAttachments(attachment))
+	interaction, err := conn.waitForInteraction(context.Background(), callbackID, func() error {
+		respChannel, respTS, err := conn.Client.PostMessage(conn.Channel, slack.MsgOptionPostMessageParameters(params), slack.MsgOptionAttachments(attachment))
+		if err != nil {
+			return xerrors.Errorf("posting message: %w", err)
+		}
+
+		fmt.Printf("respCHannel=%s, respTS=%s", respChannel, respTS)
+
+		return nil
+	})
 	if err != nil {
-		return nil, xerrors.Errorf("posting message: %w", err)
+		return nil, err
 	}
 
-	fmt.Printf("respCHannel=%s, respTS=%s", respChannel, respTS)
+	selected := interaction.ActionCallback.AttachmentActions[0].SelectedOptions[0].Value
+
+	return &selected, nil
+}
+
+// Approve posts the message with the approve and deny buttons, and tells if the approve button is clicked.
+// It fails when no button is clicked until the context is done or InteractionTimeout elapses.
+func (conn *Connection) Approve(ctx context.Context, a Approval) (bool, error) {
+	callbackID := newCallbackID()
+
+	channel := a.Channel
+	if channel == "" {
+		channel = conn.Channel
+	}
+
+	attachment := slack.Attachment{
+		Text:       a.Text,
+		Color:      "#f9a41b",
+		CallbackID: callbackID,
+		Actions: []slack.AttachmentAction{
+			{
+				Name:  actionApprove,
+				Text:  "Approve",
+				Type:  "button",
+				Style: "primary",
+			},
+			{
+				Name:  actionDeny,
+				Text:  "Deny",
+				Type:  "button",
+				Style: "danger",
+			},
+		},
+	}
+
+	params := slack.PostMessageParameters{
+		Markdown: true,
+	}
+
+	interaction, err := conn.waitForInteraction(ctx, callbackID, func() error {
+		if _, _, err := conn.Client.PostMessage(channel, slack.MsgOptionPostMessageParameters(params), slack.MsgOptionAttachments(attachment)); err != nil {
+			return xerrors.Errorf("posting message: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
 
-	// Wait for selection
+	actions := interaction.ActionCallback.AttachmentActions
+
+	return len(actions) > 0 && actions[0].Name == actionApprove, nil
+}
 
+// waitForInteraction calls post to post the interactive message, and waits for the first interaction with it.
+// The handler of the interaction is registered before posting, so that an interaction right after the post is never
+// dropped, and removed once the wait ends.
+func (conn *Connection) waitForInteraction(ctx context.Context, callbackID string, post func() error) (*slack.InteractionCallback, error) {
 	callbackCh := make(chan slack.InteractionCallback, 1)
 
 	conn.RegisterInteractionCallbackHandler(callbackID, func(interaction slack.InteractionCallback) (interface{}, error) {
-		callbackCh <- interaction
+		// Later interactions like a double click are ignored
+		select {
+		case callbackCh <- interaction:
+		default:
+		}
 
 		return &interaction.OriginalMessage, nil
 	})
 
-	interaction := <-callbackCh
+	defer conn.UnregisterInteractionCallbackHandler(callbackID)
 
-	selected := interaction.ActionCallback.AttachmentActions[0].SelectedOptions[0].Value
+	if err := post(); err != nil {
+		return nil, err
+	}
 
-	return &selected, nil
+	ctx, cancel := context.WithTimeout(ctx, InteractionTimeout)
+	defer cancel()
+
+	select {
+	case interaction := <-callbackCh:
+		return &interaction, nil
+	case <-ctx.Done():
+		return nil, xerrors.Errorf("waiting for interaction: %w", ctx.Err())
+	}
 }
 
 func (conn *Connection) RegisterInteractionCallbackHandler(callbackID string, callback InteractionCallbackHandler) {
@@ -173,6 +265,13 @@ func (conn *Connection) RegisterInteractionCallbackHandler(callbackID string, ca
 	conn.pendingInteractionsCallbackMut.Unlock()
 }
 
+// UnregisterInteractionCallbackHandler removes the handler registered for the callback ID, if any.
+func (conn *Connection) UnregisterInteractionCallbackHandler(callbackID string) {
+	conn.pendingInteractionsCallbackMut.Lock()
+	delete(conn.pendingInteractionCallbacks, callbackID)
+	conn.pendingInteractionsCallbackMut.Unlock()
+}
+
 func (conn *Connection) Run() error {
 	handler := func(interaction slack.InteractionCallback) (interface{}, error) {
 		callbackID := interaction.CallbackID
@@ -0,0 +1,57 @@
+package slack
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nlopes/slack"
+)
+
+func (conn *Connection) interact(callbackID string, interaction slack.InteractionCallback) error {
+	conn.pendingInteractionsCallbackMut.Lock()
+	callback, ok := conn.pendingInteractionCallbacks[callbackID]
+	conn.pendingInteractionsCallbackMut.Unlock()
+
+	if !ok {
+		return errors.New("no handler registered")
+	}
+
+	_, err := callback(interaction)
+
+	return err
+}
+
+func TestWaitForInteraction(t *testing.T) {
+	conn := New("test", "", "", nil)
+
+	// The interaction right after the post is received
+	interaction, err := conn.waitForInteraction(context.Background(), "fast", func() error {
+		return conn.interact("fast", slack.InteractionCallback{CallbackID: "fast"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if interaction.CallbackID != "fast" {
+		t.Errorf("unexpected interaction: %v", interaction)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The wait ends once the context is done
+	_, err = conn.waitForInteraction(ctx, "never", func() error {
+		cancel()
+
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, id := range []string{"fast", "never"} {
+		if err := conn.interact(id, slack.InteractionCallback{}); err == nil {
+			t.Errorf("handler for %q must be removed once the wait ends", id)
+		}
+	}
+}
@@ -24,10 +24,36 @@ func (r *Runner) StartSlackbot(name string) error {
 	bot := variantslack.New(name, os.Getenv("SLACK_BOT_TOKEN"), os.Getenv("SLACK_VERIFICATION_TOKEN"), func(bot *variantslack.Connection, cmd string, message slack.SlashCommand) string {
 		var b bytes.Buffer
 
-		err := r.Run(strings.Split(cmd, " "), RunOptions{
+		args := strings.Split(cmd, " ")
+
+		// Anyone in the channel can run the command, so it must never skip approvals or change how the job is run
+		for _, a := range args {
+			if f := strings.SplitN(a, "=", 2)[0]; f == "--yes" || f == "--dry-run" {
+				return fmt.Sprintf("%s can not be used via the Slack bot", f)
+			}
+		}
+
+		err := r.Run(args, RunOptions{
 			Stdout: &b,
 			Stderr: &b,
 			SetOpts: func(opts map[string]cty.Value, pendingOptions []app.PendingInput) error {
+				// Approvals are asked with the approve and deny buttons instead of a dialog
+				if len(pendingOptions) == 1 && pendingOptions[0].Approval {
+					o := pendingOptions[0]
+
+					approved, err := bot.Approve(r.runContext(), variantslack.Approval{
+						Channel: message.ChannelID,
+						Text:    fmt.Sprintf("<@%s> %s", message.UserID, *o.Description),
+					})
+					if err != nil {
+						return xerrors.Errorf("asking for approval: %w", err)
+					}
+
+					opts[o.Name] = cty.BoolVal(approved)
+
+					return nil
+				}
+
 				var elems []slack.DialogElement
 
 				for _, o := range pendingOptions {
@@ -74,9 +100,17 @@ func (r *Runner) StartSlackbot(name string) error {
 
 				done := make(chan error, 1)
 
+				// Later submissions like a double click are ignored
+				finish := func() {
+					select {
+					case done <- nil:
+					default:
+					}
+				}
+
 				bot.RegisterInteractionCallbackHandler(callbackID, func(callback slack.InteractionCallback) (interface{}, error) {
 					if callback.Type == slack.InteractionTypeDialogCancellation {
-						done <- nil
+						finish()
 
 						return nil, nil
 					}
@@ -145,18 +179,28 @@ func (r *Runner) StartSlackbot(name string) error {
 						return nil, xerrors.Errorf("setting options: %w", err)
 					}
 
-					done <- nil
+					finish()
 
 					return nil, nil
 				})
 
-				if err := bot.Client.OpenDialogContext(context.TODO(), message.TriggerID, dialog); err != nil {
+				defer bot.UnregisterInteractionCallbackHandler(callbackID)
+
+				ctx, cancel := context.WithTimeout(r.runContext(), variantslack.InteractionTimeout)
+				defer cancel()
+
+				if err := bot.Client.OpenDialogContext(ctx, message.TriggerID, dialog); err != nil {
 					log.Print("open dialog failed: ", err)
 
 					return nil
 				}
 
-				return <-done
+				select {
+				case err := <-done:
+					return err
+				case <-ctx.Done():
+					return xerrors.Errorf("waiting for the dialog to be submitted: %w", ctx.Err())
+				}
 			},
 		})
 
@@ -5,11 +5,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"golang.org/x/xerrors"
 
 	variant "github.com/mumoshu/variant2"
+	"github.com/mumoshu/variant2/pkg/app"
 )
 
 // Building the binary with `go build -o myapp main.go`
@@ -279,3 +282,275 @@ func TestNewDirCobra(t *testing.T) {
 
 // variant.(Must)Eval creates a Variant command from the virtual file name and the source code written in the Variant DSL
 // variant.(Must)Load creates a Variant command from a file or a directory
+
+func TestJobStdin(t *testing.T) {
+	source := `
+job "upper" {
+  exec {
+    command = "tr"
+    args = ["a-z", "A-Z"]
+  }
+}
+`
+
+	myapp, err := variant.Load(variant.FromSource("myapp", source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bufferCloser{}
+
+	jr, err := myapp.Job("upper", variant.State{
+		Stdin:  bytes.NewBufferString("hello world"),
+		Stdout: stdout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jr(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	if outStr := stdout.String(); outStr != "HELLO WORLD" {
+		t.Errorf("unexpected stdout: got %q", outStr)
+	}
+}
+
+func TestJobStdinWithRetry(t *testing.T) {
+	source := `
+job "upper" {
+  exec {
+    command = "sh"
+    args = ["-c", "input=$(cat); if [ ${retry.attempt} -lt 2 ]; then exit 1; fi; printf %s \"$input\" | tr a-z A-Z"]
+
+    retry {
+      attempts = 2
+    }
+  }
+}
+`
+
+	myapp, err := variant.Load(variant.FromSource("myapp", source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bufferCloser{}
+
+	jr, err := myapp.Job("upper", variant.State{
+		Stdin:  bytes.NewBufferString("hello world"),
+		Stdout: stdout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jr(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retried attempt must be fed the same stdin as the first one
+	if outStr := stdout.String(); outStr != "HELLO WORLD" {
+		t.Errorf("unexpected stdout: got %q", outStr)
+	}
+}
+
+func TestRunFlagsApplyOnlyToTheRun(t *testing.T) {
+	source := `
+job "deploy" {
+  approval {}
+
+  exec {
+    command = "echo"
+    args = ["deployed"]
+  }
+}
+`
+
+	// The empty command name makes the runner run jobs via `variant run`, which has the flags like `--yes`
+	myapp, err := variant.Load(variant.FromSource("myapp", source, func(m *variant.Main) {
+		m.Command = ""
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, error) {
+		t.Helper()
+
+		var out bytes.Buffer
+
+		err := myapp.Run(append([]string{"run", "deploy"}, args...), variant.RunOptions{
+			Stdout: &out,
+			Stderr: &out,
+		})
+
+		return out.String(), err
+	}
+
+	if out, err := run("--yes"); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out)
+	}
+
+	if _, err := run(); err == nil || !strings.Contains(err.Error(), "requires approval") {
+		t.Errorf("--yes given to the previous run must not approve the job: %v", err)
+	}
+
+	if out, err := run("--dry-run"); err != nil || strings.Contains(out, "deployed\n") {
+		t.Fatalf("unexpected result of the dry run: %v\n%s", err, out)
+	}
+
+	if out, err := run("--yes"); err != nil || !strings.Contains(out, "deployed") {
+		t.Errorf("--dry-run given to the previous run must not apply: %v\n%s", err, out)
+	}
+}
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+type recordingExecutor struct {
+	name string
+	cmds *[]string
+}
+
+func (e recordingExecutor) Run(_ context.Context, cmd app.Command) (*app.Result, error) {
+	*e.cmds = append(*e.cmds, fmt.Sprintf("%s: %s %v", e.name, cmd.Name, cmd.Args))
+
+	return &app.Result{Stdout: e.name}, nil
+}
+
+func TestExecutor(t *testing.T) {
+	source := `
+job "local" {
+  exec {
+    command = "echo"
+    args = ["local"]
+  }
+}
+
+job "remote" {
+  executor = "remote"
+
+  step "callee" {
+    run "local" {}
+  }
+}
+
+job "all" {
+  step "local" {
+    run "local" {}
+  }
+
+  step "remote" {
+    run "remote" {}
+
+    need = ["local"]
+  }
+}
+`
+
+	var cmds []string
+
+	myapp, err := variant.Load(variant.FromSource("myapp", source,
+		variant.WithExecutor(recordingExecutor{name: "default", cmds: &cmds}),
+		variant.WithNamedExecutor("remote", recordingExecutor{name: "remote", cmds: &cmds}),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bufferCloser{}
+
+	jr, err := myapp.Job("all", variant.State{
+		Stdout: stdout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jr(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"default: echo [local]",
+		"remote: echo [local]",
+	}
+
+	if d := cmp.Diff(want, cmds); d != "" {
+		t.Errorf("unexpected commands: want (-), got (+):\n%s", d)
+	}
+
+	if outStr := stdout.String(); outStr != "remote" {
+		t.Errorf("unexpected stdout: got %q", outStr)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	source := `
+job "lint" {
+  exit_code_on_failure = 1
+
+  exec {
+    command = "sh"
+    args = ["-c", "exit 7"]
+  }
+}
+
+job "crash" {
+  exec {
+    command = "sh"
+    args = ["-c", "exit 3"]
+  }
+}
+
+job "build" {
+  exit_code_on_failure = 2
+
+  step "crash" {
+    run "crash" {}
+  }
+}
+`
+
+	testcases := []struct {
+		job  string
+		want int
+	}{
+		{job: "lint", want: 1},
+		{job: "crash", want: 3},
+		{job: "build", want: 2},
+	}
+
+	for i := range testcases {
+		tc := testcases[i]
+
+		t.Run(tc.job, func(t *testing.T) {
+			err := variant.MustLoad(variant.FromSource("myapp", source)).Run([]string{tc.job}, variant.RunOptions{
+				Stdout: &bytes.Buffer{},
+				Stderr: &bytes.Buffer{},
+			})
+			if err == nil {
+				t.Fatal("expected error, got none")
+			}
+
+			var verr variant.Error
+
+			code := 1
+
+			if errors.As(err, &verr) {
+				code = verr.ExitCode
+			}
+
+			if code != tc.want {
+				t.Errorf("unexpected exit code: want %d, got %d", tc.want, code)
+			}
+		})
+	}
+}
@@ -6,11 +6,15 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/hashicorp/hcl/v2/ext/typeexpr"
 	"github.com/mattn/go-isatty"
@@ -35,6 +39,11 @@ type Main struct {
 	Getenv         func(string) string
 	Getwd          func() (string, error)
 	Setup          app.Setup
+
+	// Executor runs the commands of `exec` blocks instead of the default one running them in local processes
+	Executor app.Executor
+	// Executors are the executors selected per job by the `executor` attribute
+	Executors map[string]app.Executor
 }
 
 type Setup func() (*Main, error)
@@ -46,6 +55,24 @@ type InitParams struct {
 
 type Option func(*Main)
 
+// WithExecutor makes the Executor run the commands of all the `exec` blocks.
+func WithExecutor(e app.Executor) Option {
+	return func(m *Main) {
+		m.Executor = e
+	}
+}
+
+// WithNamedExecutor registers the Executor so that it is selected by jobs with `executor = "NAME"`.
+func WithNamedExecutor(name string, e app.Executor) Option {
+	return func(m *Main) {
+		if m.Executors == nil {
+			m.Executors = map[string]app.Executor{}
+		}
+
+		m.Executors[name] = e
+	}
+}
+
 func FromPath(path string, opts ...Option) Setup {
 	return func() (*Main, error) {
 		if path == "" {
@@ -86,16 +113,22 @@ func FromPath(path string, opts ...Option) Setup {
 	}
 }
 
-func FromSource(cmd, source string) Setup {
+func FromSource(cmd, source string, opts ...Option) Setup {
 	return func() (*Main, error) {
 		if cmd == "" {
 			return nil, errors.New("command name must be set when loadling from Variant source file")
 		}
 
-		return &Main{
+		m := &Main{
 			Command: cmd,
 			Setup:   app.FromSources(map[string][]byte{cmd: []byte(source)}),
-		}, nil
+		}
+
+		for _, o := range opts {
+			o(m)
+		}
+
+		return m, nil
 	}
 }
 
@@ -440,6 +473,8 @@ func (m *Main) initApp(setup app.Setup) (*app.App, error) {
 
 	ap.Stdout = m.Stdout
 	ap.Stderr = m.Stderr
+	ap.Executor = m.Executor
+	ap.Executors = m.Executors
 
 	return ap, nil
 }
@@ -500,7 +535,7 @@ func (m Main) initRunner(r *Runner) {
 					}()
 				}
 
-				r, err := r.ap.Run(n, st.Parameters, st.Options)
+				r, err := r.ap.RunContextWithStdin(ctx, st.Stdin, n, st.Parameters, st.Options)
 				if err != nil {
 					return xerrors.Errorf("running job %q: %w", n, err)
 				}
@@ -537,6 +572,27 @@ type Runner struct {
 
 	SetOpts app.SetOptsFunc
 
+	// ctx is the context of the ongoing Run, used to stop the job run by a cobra command
+	ctx context.Context
+
+	// timeout is the maximum duration of the whole run, set via the `--timeout` flag
+	timeout time.Duration
+
+	// dryRun is set via the `--dry-run` flag
+	dryRun bool
+
+	// resume is the ID of the failed run to be resumed, set via the `--resume` flag
+	resume string
+
+	// outputMode is how the output of steps is written, set via the `--output-mode` flag
+	outputMode string
+
+	// yes approves the jobs that require approval without asking, set via the `--yes` flag
+	yes bool
+
+	// maxParallel is the maximum number of commands run at the same time, set via the `--max-parallel` flag
+	maxParallel int
+
 	mut *sync.Mutex
 }
 
@@ -635,9 +691,22 @@ func (r *Runner) Cobra() (*cobra.Command, error) {
 				return err
 			}
 
-			_, err = ap.Run(job.Name, params, opts, r.SetOpts)
+			ctx := r.runContext()
+
+			if r.timeout > 0 {
+				var cancel context.CancelFunc
+
+				ctx, cancel = context.WithTimeout(ctx, r.timeout)
+				defer cancel()
+			}
+
+			defer r.applyRunFlags()()
+
+			_, err = ap.RunContext(ctx, job.Name, params, opts, r.SetOpts)
 			if err != nil && err.Error() != app.NoRunMessage {
 				cmd.SilenceUsage = true
+
+				return Error{Message: err.Error(), ExitCode: app.ExitCodeOf(err)}
 			}
 
 			//nolint:wrapcheck
@@ -652,6 +721,32 @@ func (r *Runner) Cobra() (*cobra.Command, error) {
 
 	rootCmd := commands[rootCmdName]
 
+	// The flags are added only to `variant run` so that they never change the interface of exported or shebang commands.
+	// Root-level options of the same names take precedence over the built-in flags.
+	if r.runCmdName == "" && rootCmd.PersistentFlags().Lookup("timeout") == nil {
+		rootCmd.PersistentFlags().DurationVar(&r.timeout, "timeout", 0, "Maximum duration of the whole run like \"30m\". Zero means no timeout")
+	}
+
+	if r.runCmdName == "" && rootCmd.PersistentFlags().Lookup("dry-run") == nil {
+		rootCmd.PersistentFlags().BoolVar(&r.dryRun, "dry-run", false, "Print the jobs, the steps grouped by waves and the commands to be run, without running any command")
+	}
+
+	if r.runCmdName == "" && rootCmd.PersistentFlags().Lookup("resume") == nil {
+		rootCmd.PersistentFlags().StringVar(&r.resume, "resume", "", "ID of the failed run to be resumed. Steps completed in the run are not run again")
+	}
+
+	if r.runCmdName == "" && rootCmd.PersistentFlags().Lookup("output-mode") == nil {
+		rootCmd.PersistentFlags().StringVar(&r.outputMode, "output-mode", "", "How the output of steps is written. Either \"raw\", \"prefixed\" to prefix each line with the step name, or \"grouped\" to write the output of each step as a block once it finishes")
+	}
+
+	if r.runCmdName == "" && rootCmd.PersistentFlags().Lookup("yes") == nil {
+		rootCmd.PersistentFlags().BoolVar(&r.yes, "yes", false, "Approve the jobs that require approval without asking. Required to run them non-interactively")
+	}
+
+	if r.runCmdName == "" && rootCmd.PersistentFlags().Lookup("max-parallel") == nil {
+		rootCmd.PersistentFlags().IntVar(&r.maxParallel, "max-parallel", 0, "Maximum number of commands run at the same time across all the jobs and steps. Defaults to the max_parallel attribute, or no limit")
+	}
+
 	return rootCmd, nil
 }
 
@@ -661,6 +756,10 @@ type RunOptions struct {
 
 	SetOpts app.SetOptsFunc
 
+	// Context, when set, stops the run once it is done.
+	// Regardless of this, the run is stopped on SIGINT and SIGTERM.
+	Context context.Context
+
 	DisableLocking bool
 }
 
@@ -719,6 +818,16 @@ func (r *Runner) Run(arguments []string, opt ...RunOptions) error {
 		}()
 	}
 
+	ctx, stop := notifyContext(opts.Context)
+	defer stop()
+
+	prevCtx := r.ctx
+	r.ctx = ctx
+
+	defer func() {
+		r.ctx = prevCtx
+	}()
+
 	if r.runCmd == nil {
 		var err error
 
@@ -754,6 +863,8 @@ func (r *Runner) Run(arguments []string, opt ...RunOptions) error {
 
 		cmd.SetArgs(arguments)
 
+		r.resetRunFlags()
+
 		if opts.Stdout != nil {
 			cmd.SetOut(opts.Stdout)
 
@@ -779,6 +890,87 @@ func (r *Runner) Run(arguments []string, opt ...RunOptions) error {
 	return err
 }
 
+// applyRunFlags sets the settings given via the flags of `variant run` to the app, and returns the func to restore the
+// previous settings, so that they never apply to later runs of the long-lived Runner like the Slack bot.
+func (r *Runner) applyRunFlags() func() {
+	ap := r.ap
+
+	dryRun, outputMode, assumeYes, maxParallel := ap.DryRun, ap.OutputMode, ap.AssumeYes, ap.MaxParallel
+	stateDir, resume := ap.StateDir, ap.Resume
+
+	if r.dryRun {
+		ap.DryRun = true
+	}
+
+	if r.outputMode != "" {
+		ap.OutputMode = r.outputMode
+	}
+
+	if r.yes {
+		ap.AssumeYes = true
+	}
+
+	if r.maxParallel != 0 {
+		ap.MaxParallel = r.maxParallel
+	}
+
+	// Only runs via `variant run` are checkpointed, as the run ID is meaningful only to the `--resume` flag
+	if r.runCmdName == "" {
+		ap.StateDir = app.DefaultStateDir()
+		ap.Resume = r.resume
+	}
+
+	return func() {
+		ap.DryRun, ap.OutputMode, ap.AssumeYes, ap.MaxParallel = dryRun, outputMode, assumeYes, maxParallel
+		ap.StateDir, ap.Resume = stateDir, resume
+	}
+}
+
+// resetRunFlags resets the fields bound to the flags of `variant run`, which otherwise keep the values given to the
+// previous run, as the cobra command is reused across runs.
+func (r *Runner) resetRunFlags() {
+	r.timeout = 0
+	r.dryRun = false
+	r.resume = ""
+	r.outputMode = ""
+	r.yes = false
+	r.maxParallel = 0
+}
+
+func (r *Runner) runContext() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+
+	return r.ctx
+}
+
+// notifyContext returns a copy of the parent context that is cancelled on SIGINT or SIGTERM.
+func notifyContext(parent context.Context) (context.Context, func()) {
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+
+	sigCh := make(chan os.Signal, 1)
+
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigCh)
+		cancel()
+	}
+}
+
 type Error struct {
 	Message  string
 	ExitCode int
@@ -889,11 +1081,79 @@ func (r *Runner) createVariantRootCommand() *cobra.Command {
 		startCmd.AddCommand(startSlackbotCmd)
 	}
 
+	cacheCmd := &cobra.Command{
+		Use:   "cache SUBCOMMAND",
+		Short: "Manage results of jobs cached by `cache` blocks",
+	}
+	{
+		cacheListCmd := &cobra.Command{
+			Use:   "list",
+			Short: "List cached results of jobs",
+			Args:  cobra.NoArgs,
+			RunE: func(c *cobra.Command, args []string) error {
+				results, err := r.ap.ListCachedResults()
+				if err != nil {
+					c.SilenceUsage = true
+
+					return err
+				}
+
+				w := tabwriter.NewWriter(c.OutOrStdout(), 0, 8, 2, ' ', 0)
+
+				fmt.Fprintln(w, "KEY\tJOB\tCREATED\tEXPIRES")
+
+				for _, res := range results {
+					key := res.Key
+					if len(key) > 12 {
+						key = key[:12]
+					}
+
+					if res.Broken {
+						fmt.Fprintf(w, "%s\t(broken)\t-\t-\n", key)
+
+						continue
+					}
+
+					expires := "never"
+
+					if !res.ExpiresAt.IsZero() {
+						expires = res.ExpiresAt.Format(time.RFC3339)
+					}
+
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key, res.Job, res.CreatedAt.Format(time.RFC3339), expires)
+				}
+
+				return w.Flush()
+			},
+		}
+
+		cacheClearCmd := &cobra.Command{
+			Use:   "clear [JOB...]",
+			Short: "Remove cached results of the jobs, or all the cached results when no job is given",
+			RunE: func(c *cobra.Command, args []string) error {
+				n, err := r.ap.ClearCachedResults(args...)
+				if err != nil {
+					c.SilenceUsage = true
+
+					return err
+				}
+
+				fmt.Fprintf(c.OutOrStdout(), "Removed %d cached result(s)\n", n)
+
+				return nil
+			},
+		}
+
+		cacheCmd.AddCommand(cacheListCmd)
+		cacheCmd.AddCommand(cacheClearCmd)
+	}
+
 	rootCmd.AddCommand(r.runCmd)
 	rootCmd.AddCommand(testCmd)
 	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(generateCmd)
 	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(cacheCmd)
 
 	return rootCmd
 }